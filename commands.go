@@ -0,0 +1,445 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"KingExporter/internal/services/kdocs"
+	"KingExporter/pkg/display"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+)
+
+const (
+	formatTable = "table"
+	formatJSON  = "json"
+)
+
+// commonFlags 是所有子命令共用的参数
+type commonFlags struct {
+	sid    string
+	silent bool
+	format string
+}
+
+func (c *commonFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.sid, "sid", "", "金山文档的会话 ID")
+	fs.BoolVar(&c.silent, "s", false, "开启静默模式")
+	fs.StringVar(&c.format, "format", formatTable, "输出格式: table 或 json")
+}
+
+func (c *commonFlags) isJSON() bool {
+	return c.format == formatJSON
+}
+
+// browser 校验 sid 后返回远程浏览器
+func (c *commonFlags) browser() *kdocs.Browser {
+	if c.format != formatTable && c.format != formatJSON {
+		display.Exit(2, "不支持的输出格式: %s", c.format)
+	}
+	if c.sid == "" {
+		if c.silent || c.isJSON() {
+			display.Exit(1, "请通过 --sid 指定金山文档的会话 ID")
+		}
+		display.PrintInput("请输入金山文档的会话 ID (sid):")
+		fmt.Scanln(&c.sid)
+	}
+	return kdocs.NewBrowser(c.sid)
+}
+
+// stringList 是可重复指定的字符串参数
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(v string) error {
+	*l = append(*l, v)
+	return nil
+}
+
+// intList 是可重复指定的整数参数
+type intList []int
+
+func (l *intList) String() string {
+	return fmt.Sprint(*l)
+}
+
+func (l *intList) Set(v string) error {
+	i, err := strconv.Atoi(v)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, i)
+	return nil
+}
+
+// selectorFlags 是 export 与 groups 共用的 group 选择参数
+type selectorFlags struct {
+	all          bool
+	ids          intList
+	names        stringList
+	types        stringList
+	excludeIDs   intList
+	excludeNames stringList
+	excludeTypes stringList
+}
+
+func (s *selectorFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&s.all, "A", false, "是否导出所有的文档，包括个人文档及团队文档")
+	fs.Var(&s.ids, "group_id", "指定空间 ID，可重复指定")
+	fs.Var(&s.names, "group-name", "按名称匹配空间，支持通配符，以 / 包裹时按正则匹配，可重复指定")
+	fs.Var(&s.types, "group-type", "按类型筛选空间，例如 special、corpspecial、normal，可重复指定")
+	fs.Var(&s.excludeIDs, "exclude-group-id", "排除指定 ID 的空间，可重复指定")
+	fs.Var(&s.excludeNames, "exclude-group-name", "排除名称匹配的空间，可重复指定")
+	fs.Var(&s.excludeTypes, "exclude-group-type", "排除指定类型的空间，可重复指定")
+}
+
+func (s *selectorFlags) selector() kdocs.GroupSelector {
+	return kdocs.GroupSelector{
+		All:          s.all,
+		IDs:          s.ids,
+		Names:        s.names,
+		Types:        s.types,
+		ExcludeIDs:   s.excludeIDs,
+		ExcludeNames: s.excludeNames,
+		ExcludeTypes: s.excludeTypes,
+	}
+}
+
+// nameFlags 是 export 与 verify 共用的文件名规则参数
+type nameFlags struct {
+	rules kdocs.NameRules
+}
+
+func (n *nameFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&n.rules.Mode, "name-rules", kdocs.NameRulesWindows, "文件名清理规则: windows 兼容 Windows、macOS 及 Linux，posix 只替换 / 及空字符")
+	fs.StringVar(&n.rules.Replacement, "name-replacement", kdocs.DefaultNameReplacement, "替换文件名中不允许的字符")
+	fs.BoolVar(&n.rules.NFC, "nfc", false, "将文件名规范化为 Unicode NFC")
+	fs.IntVar(&n.rules.MaxNameLength, "max-name-length", 0, "文件及文件夹名称的最大字节数，超过时截断并追加哈希，0 表示不限制")
+}
+
+// validate 检查文件名规则，不合法时退出
+func (n *nameFlags) validate() {
+	if err := n.rules.Validate(); err != nil {
+		display.Exit(2, "%s", err)
+	}
+}
+
+// scopeFlags 是 export 与 plan 共用的导出范围参数，决定导出哪些文件及其本地路径
+type scopeFlags struct {
+	groups      selectorFlags
+	remotePath  string
+	folderID    int
+	fileID      int
+	shared      bool
+	starred     bool
+	recent      bool
+	trash       bool
+	convertSpec string
+	convert     kdocs.ConvertMap
+	names       nameFlags
+}
+
+func (s *scopeFlags) register(fs *flag.FlagSet) {
+	s.names.register(fs)
+	s.groups.register(fs)
+	fs.StringVar(&s.remotePath, "path", "", "只导出空间中的指定远程路径，例如 /项目A/设计")
+	fs.IntVar(&s.folderID, "folder_id", 0, "只导出指定 ID 的文件夹")
+	fs.IntVar(&s.fileID, "file_id", 0, "只导出指定 ID 的单个文件")
+	fs.BoolVar(&s.shared, "shared", false, "同时导出\"与我共享\"的文档")
+	fs.BoolVar(&s.starred, "starred", false, "同时导出星标文档")
+	fs.BoolVar(&s.recent, "recent", false, "同时导出最近打开的文档")
+	fs.BoolVar(&s.trash, "include-trash", false, "同时导出回收站中的文档到 .trash/ 目录")
+	fs.StringVar(&s.convertSpec, "convert", "", "在线文档的转码格式，例如 otl=pdf,ksheet=csv 或 otl=docx+pdf")
+}
+
+// validate 检查文件名规则并解析转码格式，不合法时退出
+func (s *scopeFlags) validate() {
+	s.names.validate()
+	convert, err := kdocs.ParseConvertMap(s.convertSpec)
+	if err != nil {
+		display.Exit(2, "转码参数不合法: %s", err)
+	}
+	if unverified := convert.Unverified(); len(unverified) > 0 {
+		display.PrintError("以下转码格式尚未验证，可能导出失败，失败时在 manifest.json 中注明: %s", strings.Join(unverified, ", "))
+	}
+	s.convert = convert
+}
+
+// scopeFlagNames 返回导出范围参数的名称，apply 时这些参数取自计划
+func scopeFlagNames() []string {
+	fs := flag.NewFlagSet("", flag.ContinueOnError)
+	new(scopeFlags).register(fs)
+	var names []string
+	fs.VisitAll(func(f *flag.Flag) { names = append(names, f.Name) })
+	return names
+}
+
+// parseArgs 允许参数与位置参数交替出现，例如 ls 123 /项目A --sid xxx
+func parseArgs(fs *flag.FlagSet, args []string) []string {
+	var positional []string
+	for {
+		// ExitOnError 模式下解析失败会直接退出
+		_ = fs.Parse(args)
+		args = fs.Args()
+		if len(args) == 0 {
+			return positional
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+}
+
+func printJSON(v any) {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		display.Exit(1, "序列化 JSON 失败: %s", err)
+	}
+	fmt.Println(string(data))
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		display.Exit(1, "%s", err)
+	}
+}
+
+func runWhoami(args []string) {
+	var c commonFlags
+	fs := flag.NewFlagSet("whoami", flag.ExitOnError)
+	c.register(fs)
+	parseArgs(fs, args)
+
+	userinfo, err := c.browser().UserInfo()
+	exitOnError(err)
+
+	if c.isJSON() {
+		printJSON(userinfo)
+		return
+	}
+	display.PrintTable(os.Stdout, []string{"ID", "NAME", "STATUS"}, [][]string{
+		{cast.ToString(userinfo.ID), userinfo.Name, userinfo.Status},
+	})
+}
+
+func runGroups(args []string) {
+	var c commonFlags
+	var sf selectorFlags
+	fs := flag.NewFlagSet("groups", flag.ExitOnError)
+	c.register(fs)
+	sf.register(fs)
+	parseArgs(fs, args)
+
+	// 未指定选择条件时列出全部空间，只有排除条件时从全部空间中排除
+	selector := sf.selector()
+	if !selector.HasInclude() {
+		selector.All = true
+	}
+	groups, err := c.browser().SelectGroups(selector)
+	exitOnError(err)
+
+	if c.isJSON() {
+		printJSON(groups)
+		return
+	}
+	rows := make([][]string, 0, len(groups))
+	for _, g := range groups {
+		rows = append(rows, []string{cast.ToString(g.ID), g.Name, g.Type})
+	}
+	display.PrintTable(os.Stdout, []string{"ID", "NAME", "TYPE"}, rows)
+}
+
+func runLs(args []string) {
+	var c commonFlags
+	fs := flag.NewFlagSet("ls", flag.ExitOnError)
+	c.register(fs)
+	positional := parseArgs(fs, args)
+	if len(positional) < 1 {
+		display.Exit(2, "用法: KingExporter ls <group> [path]")
+	}
+
+	b := c.browser()
+	group, err := b.FindGroup(positional[0])
+	exitOnError(err)
+	remotePath := "/"
+	if len(positional) > 1 {
+		remotePath = positional[1]
+	}
+	files, err := b.List(group.ID, remotePath)
+	exitOnError(err)
+
+	if c.isJSON() {
+		printJSON(files)
+		return
+	}
+	rows := make([][]string, 0, len(files))
+	for _, f := range files {
+		size := display.FormatBytes(int64(f.FSize))
+		if f.FType == "folder" {
+			size = "-"
+		}
+		rows = append(rows, []string{cast.ToString(f.ID), f.FType, size, f.FName})
+	}
+	display.PrintTable(os.Stdout, []string{"ID", "TYPE", "SIZE", "NAME"}, rows)
+}
+
+func runTree(args []string) {
+	var c commonFlags
+	fs := flag.NewFlagSet("tree", flag.ExitOnError)
+	c.register(fs)
+	positional := parseArgs(fs, args)
+	if len(positional) < 1 {
+		display.Exit(2, "用法: KingExporter tree <group>")
+	}
+
+	b := c.browser()
+	group, err := b.FindGroup(positional[0])
+	exitOnError(err)
+	root, err := b.Tree(group.ID)
+	exitOnError(err)
+
+	if c.isJSON() {
+		printJSON(root)
+		return
+	}
+	fmt.Println(group.Name)
+	printTree(root, "")
+}
+
+func printTree(n *kdocs.Node, prefix string) {
+	for i, child := range n.Children {
+		branch, next := "├── ", "│   "
+		if i == len(n.Children)-1 {
+			branch, next = "└── ", "    "
+		}
+		name := child.FName
+		if child.FType == "folder" {
+			name += "/"
+		} else {
+			name += fmt.Sprintf(" (%s)", display.FormatBytes(int64(child.FSize)))
+		}
+		fmt.Println(prefix + branch + name)
+		printTree(child, prefix+next)
+	}
+}
+
+func runVerify(args []string) {
+	var c commonFlags
+	var nf nameFlags
+	var downloadDir, convertSpec string
+	fs := flag.NewFlagSet("verify", flag.ExitOnError)
+	c.register(fs)
+	nf.register(fs)
+	fs.StringVar(&downloadDir, "download_dir", "", "导出时使用的下载目录")
+	fs.StringVar(&convertSpec, "convert", "", "导出时使用的转码格式，例如 otl=docx+pdf,ksheet=csv")
+	positional := parseArgs(fs, args)
+	if len(positional) < 1 || downloadDir == "" {
+		display.Exit(2, "用法: KingExporter verify <group> --download_dir=DIR")
+	}
+	nf.validate()
+	convert, err := kdocs.ParseConvertMap(convertSpec)
+	exitOnError(err)
+
+	b := c.browser()
+	group, err := b.FindGroup(positional[0])
+	exitOnError(err)
+	results, err := b.Verify(*group, downloadDir, convert, nf.rules)
+	exitOnError(err)
+
+	var problems []kdocs.VerifyResult
+	for _, r := range results {
+		if r.Status != kdocs.VerifyOK {
+			problems = append(problems, r)
+		}
+	}
+
+	if c.isJSON() {
+		printJSON(results)
+	} else {
+		rows := make([][]string, 0, len(problems))
+		for _, r := range problems {
+			rows = append(rows, []string{r.Status, display.FormatBytes(int64(r.RemoteSize)), r.RemotePath})
+		}
+		display.PrintTable(os.Stdout, []string{"STATUS", "SIZE", "PATH"}, rows)
+		fmt.Printf("\n共 %d 个文件，%d 个异常\n", len(results), len(problems))
+	}
+	if len(problems) > 0 {
+		os.Exit(1)
+	}
+}
+
+func runPlan(args []string) {
+	var c commonFlags
+	var scope scopeFlags
+	var downloadDir, out, dedupe string
+	var mirror bool
+	fs := flag.NewFlagSet("plan", flag.ExitOnError)
+	c.register(fs)
+	scope.register(fs)
+	fs.StringVar(&out, "out", "plan.json", "导出计划的保存路径")
+	fs.StringVar(&downloadDir, "download_dir", "", "导出时使用的下载目录，用于按镜像状态标记未变化的文件，可不指定")
+	fs.BoolVar(&mirror, "mirror", false, "按下载目录中的镜像状态标记未变化的文件")
+	fs.StringVar(&dedupe, "dedupe", "", "按去重方式标记重复的文件: hardlink、reflink 或 symlink")
+	parseArgs(fs, args)
+	scope.validate()
+	if err := kdocs.ValidateDedupe(dedupe); err != nil {
+		display.Exit(2, "%s", err)
+	}
+
+	e := kdocs.NewPlanner(c.sid, kdocs.ExportOptions{
+		DownloadDir:    downloadDir,
+		SilentMode:     c.silent || c.isJSON(),
+		Groups:         scope.groups.selector(),
+		Path:           scope.remotePath,
+		FolderID:       scope.folderID,
+		FileID:         scope.fileID,
+		IncludeShared:  scope.shared,
+		IncludeStarred: scope.starred,
+		IncludeRecent:  scope.recent,
+		IncludeTrash:   scope.trash,
+		Convert:        scope.convert,
+		Mirror:         mirror,
+		Dedupe:         dedupe,
+		Names:          scope.names.rules,
+	})
+	plan := e.Plan()
+	exitOnError(plan.Save(out))
+
+	if c.isJSON() {
+		printJSON(map[string]any{"plan": out, "summary": plan.Summary})
+		return
+	}
+	plan.PrintSummary(os.Stdout)
+	fmt.Printf("导出计划已保存到 %s，共 %d 个条目\n", out, len(plan.Actions))
+}
+
+func runApply(args []string) {
+	f := parseExportFlags("apply", args)
+	if len(f.args) != 1 {
+		display.Exit(2, "用法: KingExporter apply <plan.json>")
+	}
+	if err := checkApplyFlags(f.set); err != nil {
+		display.Exit(2, "%s", err)
+	}
+
+	plan, err := kdocs.LoadPlan(f.args[0])
+	exitOnError(err)
+	export(f, plan)
+}
+
+// checkApplyFlags 检查 apply 指定的参数，导出范围、转码格式及文件名规则取自导出计划，不能再指定
+func checkApplyFlags(set []string) error {
+	scopeNames := scopeFlagNames()
+	for _, name := range set {
+		if lo.Contains(scopeNames, name) {
+			return fmt.Errorf("导出范围、转码格式及文件名规则取自导出计划，不能指定 --%s", name)
+		}
+	}
+	return nil
+}
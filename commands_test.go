@@ -0,0 +1,25 @@
+package main
+
+import "testing"
+
+func TestCheckApplyFlags(t *testing.T) {
+	tests := []struct {
+		args    []string
+		wantErr bool
+	}{
+		{[]string{"plan.json"}, false},
+		{[]string{"--download_dir", "out", "--mirror", "--max-total-size", "1G", "--check-space", "plan.json"}, false},
+		{[]string{"--path", "/项目", "plan.json"}, true},
+		{[]string{"--convert", "otl=pdf", "plan.json"}, true},
+		{[]string{"plan.json", "--include-trash"}, true},
+		{[]string{"--name-rules", "posix", "plan.json"}, true},
+		{[]string{"--nfc", "plan.json"}, true},
+		{[]string{"--file_id", "3", "plan.json"}, true},
+	}
+	for _, tt := range tests {
+		f := parseExportFlags("apply", tt.args)
+		if err := checkApplyFlags(f.set); (err != nil) != tt.wantErr {
+			t.Errorf("apply %v: 错误为 %v", tt.args, err)
+		}
+	}
+}
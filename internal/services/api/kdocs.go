@@ -2,13 +2,12 @@ package api
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
-	"path"
 
 	"KingExporter/internal/global"
 	"github.com/go-resty/resty/v2"
-	"github.com/samber/lo"
 )
 
 const KDocsSID = "wps_sid"
@@ -42,6 +41,31 @@ func (c *KDocsApi) Req(debug ...bool) *resty.Request {
 		SetHeader("Referer", c.baseHost).SetHeader("Origin", c.baseHost)
 }
 
+// errEmptyURL 表示接口成功返回但没有下载地址
+var errEmptyURL = errors.New("empty download url")
+
+// result 是接口返回的结果码，成功时为 ok，失败时为 userNotLogin 等错误码
+type result struct {
+	Result string `json:"result"`
+	Msg    string `json:"msg"`
+}
+
+// checkResponse 检查 HTTP 状态码及接口结果码。resty 对 4xx/5xx 不返回错误，
+// 不检查时过期的 sid、限流或服务端错误会被当作空列表，镜像模式据此会把本地文件当作云端已删除
+func checkResponse(resp *resty.Response) error {
+	if resp == nil {
+		return errors.New("empty response")
+	}
+	if !resp.IsSuccess() {
+		return fmt.Errorf("unexpected status %s", resp.Status())
+	}
+	var r result
+	if err := json.Unmarshal(resp.Body(), &r); err == nil && r.Result != "" && r.Result != "ok" {
+		return fmt.Errorf("result %s: %s", r.Result, r.Msg)
+	}
+	return nil
+}
+
 type UserInfo struct {
 	ID     int    `json:"id"`
 	Name   string `json:"name"`
@@ -88,8 +112,8 @@ func (c *KDocsApi) GetGroups() ([]Group, error) {
 		return nil, err
 	}
 
-	if resp == nil {
-		global.Log.Error("[KDocsApi] GetGroups empty response")
+	if err := checkResponse(resp); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] GetGroups failed: %s", err))
 		return nil, err
 	}
 	if err := json.Unmarshal(resp.Body(), &respData); err != nil {
@@ -99,12 +123,50 @@ func (c *KDocsApi) GetGroups() ([]Group, error) {
 	return respData.Groups, nil
 }
 
+type GroupMember struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+	// Role 成员角色，例如 creator、admin、member、read_member
+	Role string `json:"role"`
+}
+
+func (c *KDocsApi) GroupMembers(groupID int) ([]GroupMember, error) {
+	var data struct {
+		Members []GroupMember `json:"members"`
+	}
+	endpoint := fmt.Sprintf("%s/api/v5/groups/%d/members?offset=0&count=10000", c.driveHost, groupID)
+	resp, err := c.Req().Get(endpoint)
+
+	if err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] GroupMembers failed: %s", err.Error()))
+		return nil, err
+	}
+
+	if err := checkResponse(resp); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] GroupMembers failed: %s", err))
+		return nil, err
+	}
+	if err := json.Unmarshal(resp.Body(), &data); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] unmarshal GroupMembers error: %s", err))
+		return nil, err
+	}
+	return data.Members, nil
+}
+
 type File struct {
 	ID       int    `json:"id"`
+	GroupID  int    `json:"groupid"`
 	ParentID int    `json:"parentid"`
 	FName    string `json:"fname"`
 	FSize    int    `json:"fsize"`
 	FType    string `json:"ftype"`
+	// FSha 为文件内容的 SHA-1，在线文档可能为空
+	FSha string `json:"fsha"`
+	// CTime、MTime 为 Unix 秒
+	CTime    int64     `json:"ctime"`
+	MTime    int64     `json:"mtime"`
+	Creator  FileOwner `json:"creator"`
+	Modifier FileOwner `json:"modifier"`
 }
 
 func (c *KDocsApi) Files(groupID, parentID int) ([]File, error) {
@@ -119,8 +181,8 @@ func (c *KDocsApi) Files(groupID, parentID int) ([]File, error) {
 		return nil, err
 	}
 
-	if resp == nil {
-		global.Log.Error("[KDocsApi] Files empty response")
+	if err := checkResponse(resp); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] Files failed: %s", err))
 		return nil, err
 	}
 	if err := json.Unmarshal(resp.Body(), &data); err != nil {
@@ -130,6 +192,290 @@ func (c *KDocsApi) Files(groupID, parentID int) ([]File, error) {
 	return data.Files, nil
 }
 
+func (c *KDocsApi) FileInfo(fileID int) (*File, error) {
+	var data struct {
+		FileInfo File `json:"fileinfo"`
+	}
+	endpoint := fmt.Sprintf("%s/api/v5/files/%d/metadata", c.driveHost, fileID)
+	resp, err := c.Req().Get(endpoint)
+
+	if err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] FileInfo failed: %s", err.Error()))
+		return nil, err
+	}
+
+	if err := checkResponse(resp); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] FileInfo failed: %s", err))
+		return nil, err
+	}
+	if err := json.Unmarshal(resp.Body(), &data); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] unmarshal FileInfo error: %s", err))
+		return nil, err
+	}
+	if data.FileInfo.ID == 0 {
+		return nil, fmt.Errorf("file %d not found", fileID)
+	}
+	return &data.FileInfo, nil
+}
+
+type FileOwner struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+}
+
+// LinkedFile 是"与我共享"、"星标"、"最近"列表中的条目，指向某个 group 中的文件或文件夹
+type LinkedFile struct {
+	File
+	Owner FileOwner `json:"owner"`
+}
+
+func (c *KDocsApi) SharedWithMe() ([]LinkedFile, error) {
+	return c.linkedFiles("SharedWithMe", fmt.Sprintf("%s/api/v5/share/to_me?offset=0&count=20000", c.driveHost))
+}
+
+func (c *KDocsApi) Starred() ([]LinkedFile, error) {
+	return c.linkedFiles("Starred", fmt.Sprintf("%s/api/v5/star/items?offset=0&count=20000", c.driveHost))
+}
+
+func (c *KDocsApi) Recent() ([]LinkedFile, error) {
+	return c.linkedFiles("Recent", fmt.Sprintf("%s/api/v5/roaming?offset=0&count=20000", c.driveHost))
+}
+
+func (c *KDocsApi) linkedFiles(name, endpoint string) ([]LinkedFile, error) {
+	var data struct {
+		Files []LinkedFile `json:"files"`
+	}
+	resp, err := c.Req().Get(endpoint)
+	if err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] %s failed: %s", name, err.Error()))
+		return nil, err
+	}
+
+	if err := checkResponse(resp); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] %s failed: %s", name, err))
+		return nil, err
+	}
+	if err := json.Unmarshal(resp.Body(), &data); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] unmarshal %s error: %s", name, err))
+		return nil, err
+	}
+	return data.Files, nil
+}
+
+// TrashFile 是回收站中的文件
+type TrashFile struct {
+	File
+	// OriginalPath 删除前所在文件夹的路径
+	OriginalPath string `json:"path"`
+	// DeletedTime 删除时间，Unix 秒
+	DeletedTime int64 `json:"deleted_time"`
+}
+
+func (c *KDocsApi) Trash(groupID int) ([]TrashFile, error) {
+	var data struct {
+		Files []TrashFile `json:"deleted_files"`
+	}
+	endpoint := fmt.Sprintf("%s/api/v5/groups/%d/deleted_files?offset=0&count=20000", c.driveHost, groupID)
+	resp, err := c.Req().Get(endpoint)
+
+	if err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] Trash failed: %s", err.Error()))
+		return nil, err
+	}
+
+	if err := checkResponse(resp); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] Trash failed: %s", err))
+		return nil, err
+	}
+	if err := json.Unmarshal(resp.Body(), &data); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] unmarshal Trash error: %s", err))
+		return nil, err
+	}
+	return data.Files, nil
+}
+
+func (c *KDocsApi) GetTrashDownloadUrl(groupID, fileID int) (*PDFDownloadItem, error) {
+	var data PDFDownloadItem
+	endpoint := fmt.Sprintf("%s/api/v5/groups/%d/deleted_files/%d/download", c.driveHost, groupID, fileID)
+
+	resp, err := c.Req().Get(endpoint)
+	if err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] GetTrashDownloadUrl failed: %s", err.Error()))
+		return nil, err
+	}
+
+	if err := checkResponse(resp); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] GetTrashDownloadUrl failed: %s", err))
+		return nil, err
+	}
+	if err := json.Unmarshal(resp.Body(), &data); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] unmarshal GetTrashDownloadUrl error: %s", err))
+		return nil, err
+	}
+	if data.Url == "" {
+		global.Log.Error("[KDocsApi] GetTrashDownloadUrl empty url")
+		return nil, errEmptyURL
+	}
+	return &data, nil
+}
+
+// FileVersion 是文件的一个历史版本
+type FileVersion struct {
+	ID       int       `json:"id"`
+	Version  int       `json:"fver"`
+	FSize    int       `json:"fsize"`
+	MTime    int64     `json:"mtime"`
+	Modifier FileOwner `json:"modifier"`
+}
+
+func (c *KDocsApi) Versions(groupID, fileID int) ([]FileVersion, error) {
+	var data struct {
+		Histories []FileVersion `json:"histories"`
+	}
+	endpoint := fmt.Sprintf("%s/api/v5/groups/%d/files/%d/histories?offset=0&count=1000", c.driveHost, groupID, fileID)
+	resp, err := c.Req().Get(endpoint)
+
+	if err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] Versions failed: %s", err.Error()))
+		return nil, err
+	}
+
+	if err := checkResponse(resp); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] Versions failed: %s", err))
+		return nil, err
+	}
+	if err := json.Unmarshal(resp.Body(), &data); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] unmarshal Versions error: %s", err))
+		return nil, err
+	}
+	return data.Histories, nil
+}
+
+func (c *KDocsApi) GetVersionDownloadUrl(groupID, fileID, versionID int) (*PDFDownloadItem, error) {
+	var data PDFDownloadItem
+	endpoint := fmt.Sprintf("%s/api/v5/groups/%d/files/%d/histories/%d/download", c.driveHost, groupID, fileID, versionID)
+
+	resp, err := c.Req().Get(endpoint)
+	if err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] GetVersionDownloadUrl failed: %s", err.Error()))
+		return nil, err
+	}
+
+	if err := checkResponse(resp); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] GetVersionDownloadUrl failed: %s", err))
+		return nil, err
+	}
+	if err := json.Unmarshal(resp.Body(), &data); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] unmarshal GetVersionDownloadUrl error: %s", err))
+		return nil, err
+	}
+	if data.Url == "" {
+		global.Log.Error("[KDocsApi] GetVersionDownloadUrl empty url")
+		return nil, errEmptyURL
+	}
+	return &data, nil
+}
+
+// Comment 是文档中的一条评论或批注，Replies 为其回复
+type Comment struct {
+	ID      int    `json:"id"`
+	Content string `json:"content"`
+	// Quote 为评论所引用的原文
+	Quote    string    `json:"quote"`
+	Creator  FileOwner `json:"creator"`
+	CTime    int64     `json:"ctime"`
+	Resolved bool      `json:"resolved"`
+	Replies  []Comment `json:"replies"`
+}
+
+func (c *KDocsApi) Comments(fileID int) ([]Comment, error) {
+	var data struct {
+		Comments []Comment `json:"comments"`
+	}
+	endpoint := fmt.Sprintf("%s/api/v3/office/file/%d/comments?offset=0&count=1000", c.baseHost, fileID)
+	resp, err := c.Req().Get(endpoint)
+
+	if err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] Comments failed: %s", err.Error()))
+		return nil, err
+	}
+
+	if err := checkResponse(resp); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] Comments failed: %s", err))
+		return nil, err
+	}
+	if err := json.Unmarshal(resp.Body(), &data); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] unmarshal Comments error: %s", err))
+		return nil, err
+	}
+	return data.Comments, nil
+}
+
+// ShareLink 是文件或文件夹的分享链接
+type ShareLink struct {
+	SID string `json:"sid"`
+	Url string `json:"url"`
+	// Permission 链接权限，例如 read、write
+	Permission string `json:"permission"`
+	// Range 链接可访问范围，例如 anyone、company
+	Range      string    `json:"ranges"`
+	ExpireTime int64     `json:"expire_time"`
+	Creator    FileOwner `json:"creator"`
+}
+
+// Collaborator 是被单独授权访问文件或文件夹的用户
+type Collaborator struct {
+	ID         int    `json:"id"`
+	Name       string `json:"name"`
+	Permission string `json:"permission"`
+}
+
+func (c *KDocsApi) ShareLinks(fileID int) ([]ShareLink, error) {
+	var data struct {
+		Links []ShareLink `json:"links"`
+	}
+	endpoint := fmt.Sprintf("%s/api/v5/files/%d/links", c.driveHost, fileID)
+	resp, err := c.Req().Get(endpoint)
+
+	if err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] ShareLinks failed: %s", err.Error()))
+		return nil, err
+	}
+
+	if err := checkResponse(resp); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] ShareLinks failed: %s", err))
+		return nil, err
+	}
+	if err := json.Unmarshal(resp.Body(), &data); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] unmarshal ShareLinks error: %s", err))
+		return nil, err
+	}
+	return data.Links, nil
+}
+
+func (c *KDocsApi) Collaborators(groupID, fileID int) ([]Collaborator, error) {
+	var data struct {
+		Permissions []Collaborator `json:"permissions"`
+	}
+	endpoint := fmt.Sprintf("%s/api/v5/groups/%d/files/%d/permissions", c.driveHost, groupID, fileID)
+	resp, err := c.Req().Get(endpoint)
+
+	if err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] Collaborators failed: %s", err.Error()))
+		return nil, err
+	}
+
+	if err := checkResponse(resp); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] Collaborators failed: %s", err))
+		return nil, err
+	}
+	if err := json.Unmarshal(resp.Body(), &data); err != nil {
+		global.Log.Error(fmt.Sprintf("[KDocsApi] unmarshal Collaborators error: %s", err))
+		return nil, err
+	}
+	return data.Permissions, nil
+}
+
 type DownloadItem struct {
 	DownloadUrl string `json:"download_url"`
 	Url         string `json:"url"`
@@ -201,9 +547,8 @@ type ExportResult struct {
 	} `json:"data"`
 }
 
-func (c *KDocsApi) PreloadExport(fileID int, filename string) (*ExportPreload, error) {
+func (c *KDocsApi) PreloadExport(fileID int, format string) (*ExportPreload, error) {
 	var data ExportPreload
-	format := c.GetFormat(filename)
 	endpoint := fmt.Sprintf("%s/api/v3/office/file/%d/export/%s/preload", c.baseHost, fileID, format)
 	resp, err := c.Req().SetBody(map[string]string{
 		"ver":    "3",
@@ -252,8 +597,3 @@ func (c *KDocsApi) ExportProgress(fileID int, taskID, taskType, format string) (
 
 	return &data, nil
 }
-
-func (c *KDocsApi) GetFormat(fName string) string {
-	ext := path.Ext(fName)
-	return lo.If(ext == ".otl", "docx").Else("xlsx")
-}
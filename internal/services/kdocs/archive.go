@@ -0,0 +1,219 @@
+package kdocs
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 支持的归档格式，按文件扩展名识别
+const (
+	ArchiveZip   = ".zip"
+	ArchiveTarGz = ".tar.gz"
+	ArchiveTgz   = ".tgz"
+)
+
+// archiveExt 返回归档文件的格式扩展名，不支持时返回错误
+func archiveExt(p string) (string, error) {
+	lower := strings.ToLower(p)
+	for _, ext := range []string{ArchiveZip, ArchiveTarGz, ArchiveTgz} {
+		if strings.HasSuffix(lower, ext) {
+			return ext, nil
+		}
+	}
+	return "", fmt.Errorf("不支持的归档格式 %s，可选 .zip、.tar.gz、.tgz", p)
+}
+
+// archive 将导出结果写入单个 zip 或 tar.gz 文件。
+//
+// Create 返回的 writer 先写入临时文件，Close 时加锁串行写入归档并删除临时文件，
+// 因此磁盘上最多只保留正在下载的文件，各 worker 的写入不会交错。
+type archive struct {
+	mu   sync.Mutex
+	file *os.File
+	zw   *zip.Writer
+	gw   *gzip.Writer
+	tw   *tar.Writer
+	// entries 记录已写入的条目，目录以 / 结尾
+	entries map[string]FileInfo
+	// spoolDir 存放写入中的临时文件
+	spoolDir string
+}
+
+func newArchive(p string) (*archive, error) {
+	ext, err := archiveExt(p)
+	if err != nil {
+		return nil, err
+	}
+	spoolDir, err := os.MkdirTemp("", "kingexporter-spool-")
+	if err != nil {
+		return nil, fmt.Errorf("创建临时目录失败: %w", err)
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		os.RemoveAll(spoolDir)
+		return nil, fmt.Errorf("创建归档文件失败: %w", err)
+	}
+
+	a := &archive{file: f, entries: map[string]FileInfo{}, spoolDir: spoolDir}
+	if ext == ArchiveZip {
+		a.zw = zip.NewWriter(f)
+	} else {
+		a.gw = gzip.NewWriter(f)
+		a.tw = tar.NewWriter(a.gw)
+	}
+	return a, nil
+}
+
+// MkdirAll 文件所在的目录无需单独的目录条目，文件夹的条目在 Finalize 时写入
+func (a *archive) MkdirAll(string) error { return nil }
+
+func (a *archive) Create(name string, info FileInfo) (io.WriteCloser, error) {
+	f, err := os.CreateTemp(a.spoolDir, "file-")
+	if err != nil {
+		return nil, fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	modTime := info.ModTime
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	return &archiveFile{File: f, a: a, name: name, modTime: modTime}, nil
+}
+
+// Finalize 文件的修改时间在写入时已确定；未写入过的名称视为文件夹，
+// 写入带修改时间的目录条目，用于保留空文件夹
+func (a *archive) Finalize(name string, info FileInfo) error {
+	name = strings.Trim(name, "/")
+	if name == "" || name == "." {
+		return nil
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if _, ok := a.entries[name]; ok {
+		return nil
+	}
+	if _, ok := a.entries[name+"/"]; ok {
+		return nil
+	}
+	modTime := info.ModTime
+	if modTime.IsZero() {
+		modTime = time.Now()
+	}
+	a.entries[name+"/"] = FileInfo{ModTime: modTime}
+
+	if a.zw != nil {
+		_, err := a.zw.CreateHeader(&zip.FileHeader{Name: name + "/", Modified: modTime})
+		return err
+	}
+	return a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name + "/",
+		Mode:     0755,
+		ModTime:  modTime,
+	})
+}
+
+func (a *archive) Stat(name string) (FileInfo, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	info, ok := a.entries[name]
+	if !ok {
+		return FileInfo{}, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+	}
+	return info, nil
+}
+
+// Remove 归档中的条目写入后无法删除
+func (a *archive) Remove(name string) error {
+	return fmt.Errorf("归档中的文件无法删除: %s", name)
+}
+
+// add 将 r 中 size 字节写入名为 name 的条目
+func (a *archive) add(name string, r io.Reader, size int64, modTime time.Time) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	a.entries[name] = FileInfo{Size: size, ModTime: modTime}
+	if a.zw != nil {
+		w, err := a.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: modTime})
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(w, r)
+		return err
+	}
+
+	if err := a.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  modTime,
+	}); err != nil {
+		return err
+	}
+	_, err := io.CopyN(a.tw, r, size)
+	return err
+}
+
+// Close 写入归档结尾并删除临时目录
+func (a *archive) Close() error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	defer os.RemoveAll(a.spoolDir)
+
+	var err error
+	if a.zw != nil {
+		err = a.zw.Close()
+	} else {
+		err = a.tw.Close()
+		if gerr := a.gw.Close(); err == nil {
+			err = gerr
+		}
+	}
+	if cerr := a.file.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("写入归档文件失败: %w", err)
+	}
+	return nil
+}
+
+// archiveFile 是写入中的归档条目，内容暂存在临时文件中
+type archiveFile struct {
+	*os.File
+	a       *archive
+	name    string
+	modTime time.Time
+}
+
+func (f *archiveFile) Close() error {
+	defer os.Remove(f.Name())
+
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err == nil {
+		err = f.a.add(f.name, f.File, size, f.modTime)
+	}
+	if cerr := f.File.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// CloseWithError 放弃写入并删除临时文件
+func (f *archiveFile) CloseWithError(error) error {
+	f.File.Close()
+	return os.Remove(f.Name())
+}
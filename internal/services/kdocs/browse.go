@@ -0,0 +1,191 @@
+package kdocs
+
+import (
+	"fmt"
+	"path"
+
+	"KingExporter/internal/global"
+	"KingExporter/internal/services/api"
+	"github.com/samber/lo"
+	"github.com/spf13/cast"
+)
+
+// Browser 提供只读的远程浏览能力，供 whoami / groups / ls / tree / verify 等子命令使用
+type Browser struct {
+	api *api.KDocsApi
+}
+
+// Node 表示远程目录树中的一个节点
+type Node struct {
+	api.File
+	Path     string  `json:"path"`
+	Children []*Node `json:"children,omitempty"`
+}
+
+// VerifyResult 记录一个远程文件在本地的校验结果
+type VerifyResult struct {
+	FileID     int    `json:"file_id"`
+	RemotePath string `json:"remote_path"`
+	LocalPath  string `json:"local_path"`
+	RemoteSize int    `json:"remote_size"`
+	LocalSize  int64  `json:"local_size"`
+	Status     string `json:"status"`
+}
+
+const (
+	VerifyOK           = "ok"
+	VerifyMissing      = "missing"
+	VerifySizeMismatch = "size_mismatch"
+)
+
+func NewBrowser(sid string) *Browser {
+	return &Browser{api: api.NewKDocsApi(ApiHostBase, ApiHostDrive, sid)}
+}
+
+func (b *Browser) UserInfo() (*api.UserInfo, error) {
+	userinfo, err := b.api.UserInfo()
+	if err != nil {
+		return nil, fmt.Errorf("获取用户信息失败: %w", err)
+	}
+	if userinfo.ID == 0 {
+		return nil, fmt.Errorf("获取用户信息失败，请检查 sid 是否有效")
+	}
+	return userinfo, nil
+}
+
+func (b *Browser) Groups() ([]api.Group, error) {
+	groups, err := b.api.GetGroups()
+	if err != nil {
+		return nil, fmt.Errorf("获取我的云文件及团队 group 失败: %w", err)
+	}
+	return groups, nil
+}
+
+// SelectGroups 返回符合 selector 条件的 group
+func (b *Browser) SelectGroups(selector GroupSelector) ([]api.Group, error) {
+	groups, err := b.Groups()
+	if err != nil {
+		return nil, err
+	}
+	return selector.Select(groups)
+}
+
+// FindGroup 按 ID 或名称查找 group
+func (b *Browser) FindGroup(key string) (*api.Group, error) {
+	groups, err := b.Groups()
+	if err != nil {
+		return nil, err
+	}
+
+	id := cast.ToInt(key)
+	for _, v := range groups {
+		if (id > 0 && v.ID == id) || v.Name == key {
+			return &v, nil
+		}
+	}
+	return nil, fmt.Errorf("group %s 不存在", key)
+}
+
+// ResolvePath 从 group 根目录开始逐级查找远程路径，返回目标文件夹的 ID
+func (b *Browser) ResolvePath(groupID int, remotePath string) (int, error) {
+	return resolveRemotePath(b.api, groupID, remotePath)
+}
+
+// List 列出 group 中指定远程路径下的文件
+func (b *Browser) List(groupID int, remotePath string) ([]api.File, error) {
+	folderID, err := b.ResolvePath(groupID, remotePath)
+	if err != nil {
+		return nil, err
+	}
+
+	files, err := b.api.Files(groupID, folderID)
+	if err != nil {
+		return nil, fmt.Errorf("获取目录文件失败 folderID %d: %w", folderID, err)
+	}
+	return files, nil
+}
+
+// Tree 递归获取 group 的完整目录树，任一文件夹获取失败时返回错误
+func (b *Browser) Tree(groupID int) (*Node, error) {
+	root := &Node{Path: "/", File: api.File{FName: "/", FType: "folder"}}
+	if err := b.walk(groupID, root); err != nil {
+		return nil, err
+	}
+	return root, nil
+}
+
+func (b *Browser) walk(groupID int, parent *Node) error {
+	files, err := b.api.Files(groupID, parent.ID)
+	if err != nil {
+		err = fmt.Errorf("获取目录文件失败 %s folderID %d: %w", parent.Path, parent.ID, err)
+		global.Log.Error(err.Error())
+		return err
+	}
+
+	for _, f := range files {
+		node := &Node{File: f, Path: joinRemotePath(parent.Path, f.FName)}
+		parent.Children = append(parent.Children, node)
+		if f.FType != "folder" {
+			continue
+		}
+		// 子文件夹获取失败时整棵树不完整，verify 不能据此报告校验通过
+		if err := b.walk(groupID, node); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Verify 对比远程目录树与本地导出目录，找出缺失或大小不一致的文件，convert 及 names 需与导出时一致
+func (b *Browser) Verify(group api.Group, downloadDir string, convert ConvertMap, names NameRules) ([]VerifyResult, error) {
+	return b.VerifySink(group, NewLocalSink(downloadDir), convert, names)
+}
+
+// VerifySink 对比远程目录树与 Sink 中的导出结果
+func (b *Browser) VerifySink(group api.Group, sink Sink, convert ConvertMap, names NameRules) ([]VerifyResult, error) {
+	root, err := b.Tree(group.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	var results []VerifyResult
+	var visit func(n *Node, dir string)
+	visit = func(n *Node, dir string) {
+		files := lo.Map(n.Children, func(c *Node, _ int) api.File { return c.File })
+		suffixes := collisionSuffixes(files, names, func(f api.File) []string { return targetNames(f, convert) })
+		for _, child := range n.Children {
+			if child.FType == "folder" {
+				visit(child, path.Join(dir, names.localName(child.FName, suffixes[child.ID], true)))
+				continue
+			}
+			for _, t := range exportTargets(child.File, convert) {
+				name := path.Join(dir, names.localName(t.Name, suffixes[child.ID], false))
+				localPath := name
+				if local, ok := sink.(localPather); ok {
+					localPath = local.LocalPath(name)
+				}
+				r := VerifyResult{
+					FileID:     child.ID,
+					RemotePath: child.Path,
+					LocalPath:  localPath,
+					RemoteSize: child.FSize,
+					Status:     VerifyOK,
+				}
+				info, err := sink.Stat(name)
+				if err != nil {
+					r.Status = VerifyMissing
+				} else {
+					r.LocalSize = info.Size
+					// 转码后的文件大小与云端不一致，只校验是否存在
+					if t.Format == "" && info.Size != int64(child.FSize) {
+						r.Status = VerifySizeMismatch
+					}
+				}
+				results = append(results, r)
+			}
+		}
+	}
+	visit(root, names.localName(group.Name, "", true))
+
+	return results, nil
+}
@@ -0,0 +1,84 @@
+package kdocs
+
+import (
+	"errors"
+	"sync"
+
+	"KingExporter/pkg/display"
+)
+
+// errBudgetExceeded 表示下载量已达到上限，文件未下载
+var errBudgetExceeded = errors.New("已达到下载上限，未下载")
+
+// budget 限制一次导出的总下载量，并发安全
+type budget struct {
+	mu       sync.Mutex
+	limit    int64
+	used     int64
+	exceeded bool
+	// skipped 为达到上限后未下载的文件数
+	skipped int
+}
+
+func newBudget(limit int64) *budget {
+	return &budget{limit: limit}
+}
+
+// reserve 为 size 字节的下载预留额度。超过上限时返回 false，此后的下载全部跳过，
+// 而不是继续下载能放下的小文件，使导出结果停在一个明确的位置
+func (b *budget) reserve(size int64) bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.exceeded || b.used+size > b.limit {
+		b.exceeded = true
+		b.skipped++
+		return false
+	}
+	b.used += size
+	return true
+}
+
+// release 归还下载失败的文件预留的额度
+func (b *budget) release(size int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.used -= size
+}
+
+// skip 记录达到上限后跳过的文件
+func (b *budget) skip() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.skipped++
+}
+
+// exhausted 判断是否已达到上限
+func (b *budget) exhausted() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.exceeded
+}
+
+// exhausted 判断是否已达到下载上限，此时不再处理新的文件。
+// 镜像模式下 st 视为遍历不完整，未处理的文件不会被当作云端已删除
+func (e *Exporter) exhausted(st *state) bool {
+	if e.budget == nil || !e.budget.exhausted() {
+		return false
+	}
+	if e.mirror != nil {
+		e.mirror.markIncomplete(e.mirrorScope(st))
+	}
+	return true
+}
+
+// printBudget 输出下载上限的使用情况
+func (e *Exporter) printBudget() {
+	b := e.budget
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !b.exceeded {
+		return
+	}
+	display.Progress("⚠️ 已达到下载上限 %s: 已下载 %s，跳过 %d 个文件，未处理的目录不再遍历",
+		display.FormatBytes(b.limit), display.FormatBytes(b.used), b.skipped)
+}
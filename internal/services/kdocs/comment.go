@@ -0,0 +1,78 @@
+package kdocs
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"KingExporter/internal/services/api"
+)
+
+const (
+	CommentsJSONSuffix     = ".comments.json"
+	CommentsMarkdownSuffix = ".comments.md"
+)
+
+// commentFile 是写入 <name>.comments.json 的内容
+type commentFile struct {
+	FileID   int           `json:"file_id"`
+	GroupID  int           `json:"group_id"`
+	Name     string        `json:"name"`
+	Comments []api.Comment `json:"comments"`
+}
+
+// processComments 获取文档的评论并写入 fullPath 旁的 JSON 及 Markdown 文件
+func (e *Exporter) processComments(f api.File, groupID int, fullPath string) error {
+	comments, err := e.api.Comments(f.ID)
+	if err != nil {
+		return fmt.Errorf("获取评论失败 fileID %d: %w", f.ID, err)
+	}
+	if len(comments) == 0 {
+		return nil
+	}
+
+	data, err := json.MarshalIndent(commentFile{
+		FileID:   f.ID,
+		GroupID:  groupID,
+		Name:     f.FName,
+		Comments: comments,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化评论失败: %w", err)
+	}
+	if err := e.writeFile(fullPath+CommentsJSONSuffix, data); err != nil {
+		return fmt.Errorf("写入评论失败 %s: %w", fullPath, err)
+	}
+
+	if err := e.writeFile(fullPath+CommentsMarkdownSuffix, []byte(renderComments(f, comments))); err != nil {
+		return fmt.Errorf("写入评论失败 %s: %w", fullPath, err)
+	}
+	return nil
+}
+
+// renderComments 将评论渲染为便于阅读的 Markdown
+func renderComments(f api.File, comments []api.Comment) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s 的评论\n\n", f.FName)
+	fmt.Fprintf(&b, "文件 ID: %d\n", f.ID)
+
+	for i, c := range comments {
+		fmt.Fprintf(&b, "\n## #%d", i+1)
+		if c.Resolved {
+			b.WriteString("（已解决）")
+		}
+		b.WriteString("\n\n")
+		if c.Quote != "" {
+			for _, line := range strings.Split(c.Quote, "\n") {
+				fmt.Fprintf(&b, "> %s\n", line)
+			}
+			b.WriteString("\n")
+		}
+		fmt.Fprintf(&b, "**%s** %s\n\n%s\n", c.Creator.Name, formatUnix(c.CTime), c.Content)
+
+		for _, r := range c.Replies {
+			fmt.Fprintf(&b, "\n- **%s** %s: %s\n", r.Creator.Name, formatUnix(r.CTime), strings.ReplaceAll(r.Content, "\n", " "))
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,152 @@
+package kdocs
+
+import (
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"KingExporter/internal/services/api"
+	"KingExporter/pkg/utils"
+	"github.com/samber/lo"
+)
+
+// directExts 是可以直接下载的文件类型
+var directExts = []string{".docx", ".pptx", ".doc", ".ppt", ".xls", ".xlsx", ".pdf"}
+
+// nativeType 描述一种需要通过预导出接口转码的金山文档在线格式
+type nativeType struct {
+	Name string
+	// Formats 支持的导出格式，第一个为默认格式
+	Formats []string
+	// Verified 为已确认可通过预导出接口导出的格式。其余扩展名及格式按网页版的导出选项登记，
+	// 尚未在接口上验证，尽力导出
+	Verified []string
+}
+
+// nativeTypes 按扩展名登记所有在线格式，新增类型只需在此登记
+var nativeTypes = map[string]nativeType{
+	".otl":        {Name: "智能文档", Formats: []string{"docx", "pdf"}, Verified: []string{"docx"}},
+	".ksheet":     {Name: "智能表格", Formats: []string{"xlsx", "pdf", "csv"}, Verified: []string{"xlsx"}},
+	".dbt":        {Name: "多维表格", Formats: []string{"xlsx", "csv"}},
+	".form":       {Name: "表单", Formats: []string{"xlsx", "csv"}},
+	".pom":        {Name: "思维导图", Formats: []string{"xmind", "png", "svg", "pdf"}},
+	".pof":        {Name: "流程图", Formats: []string{"png", "svg", "pdf"}},
+	".whiteboard": {Name: "白板", Formats: []string{"png", "svg", "pdf"}},
+}
+
+// verified 判断 f 转码为 format 是否已确认受预导出接口支持
+func verified(f api.File, format string) bool {
+	return lo.Contains(nativeTypes[filepath.Ext(f.FName)].Verified, format)
+}
+
+// ConvertMap 指定在线文档转码的目标格式，key 为带点的扩展名，例如 ".otl" -> ["docx", "pdf"]
+type ConvertMap map[string][]string
+
+// ParseConvertMap 解析 --convert 参数，例如 "otl=pdf,ksheet=csv" 或 "otl=docx+pdf"
+func ParseConvertMap(spec string) (ConvertMap, error) {
+	m := ConvertMap{}
+	for _, item := range strings.Split(spec, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		ext, formats, ok := strings.Cut(item, "=")
+		if !ok || formats == "" {
+			return nil, fmt.Errorf("转码参数 %s 格式错误，应为 类型=格式，例如 otl=docx+pdf", item)
+		}
+		ext = "." + strings.TrimPrefix(strings.ToLower(strings.TrimSpace(ext)), ".")
+		for _, format := range strings.Split(formats, "+") {
+			m[ext] = append(m[ext], strings.ToLower(strings.TrimSpace(format)))
+		}
+	}
+
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// Validate 检查每种在线格式的目标格式是否受支持
+func (m ConvertMap) Validate() error {
+	for ext, formats := range m {
+		t, ok := nativeTypes[ext]
+		if !ok {
+			return fmt.Errorf("不支持转码的文档类型 %s，可选类型: %s", ext, strings.Join(nativeExts(), ", "))
+		}
+		for _, format := range formats {
+			if !lo.Contains(t.Formats, format) {
+				return fmt.Errorf("%s（%s）不支持导出为 %s，可选格式: %s", ext, t.Name, format, strings.Join(t.Formats, ", "))
+			}
+		}
+	}
+	return nil
+}
+
+// Unverified 返回指定的转码格式中尚未确认受预导出接口支持的项，格式为 ext=format，按扩展名排序
+func (m ConvertMap) Unverified() []string {
+	var items []string
+	for _, ext := range lo.Keys(m) {
+		for _, format := range lo.Uniq(m[ext]) {
+			if !lo.Contains(nativeTypes[ext].Verified, format) {
+				items = append(items, strings.TrimPrefix(ext, ".")+"="+format)
+			}
+		}
+	}
+	sort.Strings(items)
+	return items
+}
+
+// Formats 返回在线格式的目标格式，未指定时使用默认格式
+func (m ConvertMap) Formats(ext string) []string {
+	if formats, ok := m[ext]; ok && len(formats) > 0 {
+		return lo.Uniq(formats)
+	}
+	if t, ok := nativeTypes[ext]; ok {
+		return t.Formats[:1]
+	}
+	return nil
+}
+
+func nativeExts() []string {
+	exts := lo.Keys(nativeTypes)
+	sort.Strings(exts)
+	return exts
+}
+
+// isNative 判断文件是否为需要转码的金山文档在线格式
+func isNative(f api.File) bool {
+	_, ok := nativeTypes[filepath.Ext(f.FName)]
+	return ok
+}
+
+// exportTarget 是云文件导出到本地后的一个结果，Format 不为空时需要转码
+type exportTarget struct {
+	Name   string
+	Format string
+}
+
+// target 区分同一文件的多个导出结果，直接下载时为扩展名，转码时为目标格式
+func (t exportTarget) target() string {
+	if t.Format != "" {
+		return t.Format
+	}
+	return strings.ToLower(filepath.Ext(t.Name))
+}
+
+// exportTargets 返回云文件导出到本地后的文件，为空表示该类型不导出
+func exportTargets(f api.File, convert ConvertMap) []exportTarget {
+	ext := filepath.Ext(f.FName)
+	if lo.Contains(directExts, ext) {
+		return []exportTarget{{Name: f.FName}}
+	}
+
+	var targets []exportTarget
+	for _, format := range convert.Formats(ext) {
+		targets = append(targets, exportTarget{
+			Name:   utils.ReplaceExt(f.FName, "."+format),
+			Format: format,
+		})
+	}
+	return targets
+}
@@ -0,0 +1,28 @@
+package kdocs
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestConvertMapUnverified(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []string
+	}{
+		{"", nil},
+		{"otl=docx,ksheet=xlsx", nil},
+		{"otl=docx+pdf", []string{"otl=pdf"}},
+		{"pom=xmind,dbt=xlsx+csv,otl=docx", []string{"dbt=csv", "dbt=xlsx", "pom=xmind"}},
+		{"whiteboard=png+png", []string{"whiteboard=png"}},
+	}
+	for _, tt := range tests {
+		m, err := ParseConvertMap(tt.spec)
+		if err != nil {
+			t.Fatalf("ParseConvertMap(%q): %v", tt.spec, err)
+		}
+		if got := m.Unverified(); !slices.Equal(got, tt.want) {
+			t.Errorf("%q 未验证的格式为 %q，应为 %q", tt.spec, got, tt.want)
+		}
+	}
+}
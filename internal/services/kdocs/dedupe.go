@@ -0,0 +1,243 @@
+package kdocs
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+
+	"KingExporter/internal/global"
+	"KingExporter/internal/services/api"
+	"KingExporter/pkg/display"
+)
+
+// 重复文件的生成方式
+const (
+	DedupeHardlink = "hardlink"
+	DedupeReflink  = "reflink"
+	DedupeSymlink  = "symlink"
+)
+
+// ValidateDedupe 检查重复文件的生成方式，空字符串表示不去重
+func ValidateDedupe(mode string) error {
+	switch mode {
+	case "", DedupeHardlink, DedupeReflink, DedupeSymlink:
+		return nil
+	}
+	return fmt.Errorf("不支持的去重方式 %s，可选 hardlink、reflink、symlink", mode)
+}
+
+// DedupeSummary 汇总一次导出中去重的文件
+type DedupeSummary struct {
+	Mode string `json:"mode"`
+	// Files 为未重复下载的文件数，SavedBytes 为节省的下载量
+	Files      int   `json:"files"`
+	SavedBytes int64 `json:"saved_bytes"`
+	// Copied 为无法链接而复制生成的文件数，例如跨文件系统或文件系统不支持
+	Copied int `json:"copied,omitempty"`
+}
+
+// dedupeSource 是同一内容第一次出现的导出结果，done 关闭后 ok 表示是否导出成功
+type dedupeSource struct {
+	path string
+	done chan struct{}
+	ok   bool
+}
+
+// dedupeLink 是等待源文件导出完成后生成的重复文件
+type dedupeLink struct {
+	source   *dedupeSource
+	fullPath string
+	entry    ManifestEntry
+	file     api.File
+}
+
+// dedupe 在一次导出中按校验和或文件 ID 识别重复的文件，只下载第一份，并发安全
+type dedupe struct {
+	mu      sync.Mutex
+	mode    string
+	sources map[string]*dedupeSource
+	// byPath 为等待导出结果的源文件，键为相对于下载目录的路径
+	byPath  map[string]*dedupeSource
+	summary DedupeSummary
+}
+
+func newDedupe(mode string) *dedupe {
+	return &dedupe{
+		mode:    mode,
+		sources: map[string]*dedupeSource{},
+		byPath:  map[string]*dedupeSource{},
+		summary: DedupeSummary{Mode: mode},
+	}
+}
+
+// dedupeKey 返回导出结果的内容标识：有校验和时按校验和，否则按文件 ID 及修改时间，
+// 例如同时出现在 group 及"与我共享"中的文件。target 区分同一内容的不同转码格式
+func dedupeKey(f api.File, target string) string {
+	if f.FSha != "" {
+		return "sha:" + f.FSha + "/" + target
+	}
+	return "id:" + strconv.Itoa(f.ID) + "/" + strconv.FormatInt(f.MTime, 10) + "/" + target
+}
+
+// claim 登记 key 的导出结果 name，已有相同内容时返回其源文件
+func (d *dedupe) claim(key, name string) (*dedupeSource, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if s, ok := d.sources[key]; ok {
+		return s, true
+	}
+	s := &dedupeSource{path: name, done: make(chan struct{})}
+	d.sources[key] = s
+	d.byPath[name] = s
+	return s, false
+}
+
+// seed 登记已存在、无需下载的源文件，例如镜像模式下未变化的文件
+func (d *dedupe) seed(key, name string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if _, ok := d.sources[key]; ok {
+		return
+	}
+	s := &dedupeSource{path: name, done: make(chan struct{}), ok: true}
+	close(s.done)
+	d.sources[key] = s
+}
+
+// resolve 在源文件导出结束后通知等待的重复文件
+func (d *dedupe) resolve(name string, ok bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if s, found := d.byPath[name]; found {
+		s.ok = ok
+		close(s.done)
+		delete(d.byPath, name)
+	}
+}
+
+func (d *dedupe) add(size int64, copied bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.summary.Files++
+	d.summary.SavedBytes += size
+	if copied {
+		d.summary.Copied++
+	}
+}
+
+// dedupe 在去重模式下登记导出结果，已有相同内容时记录为待生成的重复文件并返回 true
+func (c *HandleContext) dedupe(entry ManifestEntry, fullPath, target string) bool {
+	if c.e.dedupe == nil {
+		return false
+	}
+	source, dup := c.e.dedupe.claim(dedupeKey(c.File, target), entry.LocalPath)
+	if !dup {
+		return false
+	}
+	c.st.links = append(c.st.links, dedupeLink{
+		source:   source,
+		fullPath: fullPath,
+		entry:    entry,
+		file:     c.File,
+	})
+	return true
+}
+
+// materializeLinks 在 st 的下载全部结束后生成重复文件。源文件可能属于其他 group，
+// 此时等待其导出结束；各 group 只在自身下载结束后等待，因此不会互相阻塞
+func (e *Exporter) materializeLinks(st *state) {
+	for _, l := range st.links {
+		<-l.source.done
+		l.entry.DedupeOf = l.source.path
+		if !l.source.ok {
+			e.record(l.entry, fmt.Errorf("相同内容的文件 %s 导出失败", l.source.path))
+			continue
+		}
+
+		src := filepath.Join(e.downloadDir, filepath.FromSlash(l.source.path))
+		stat, err := os.Stat(src)
+		copied := false
+		if err == nil {
+			if copied, err = e.link(src, l.fullPath); err == nil {
+				e.dedupe.add(stat.Size(), copied)
+			}
+		}
+		if err != nil {
+			global.Log.Error("生成重复文件失败 %s: %v", l.fullPath, err)
+		} else if copied || e.dedupe.mode == DedupeReflink {
+			e.finalizeFile(l.fullPath, l.file)
+			e.postProcess(l.fullPath)
+		} else {
+			// 硬链接及符号链接与源文件共享修改时间，只写入元数据
+			if e.writeMeta {
+				if err := e.writeMetaFile(l.fullPath, l.file); err != nil {
+					global.Log.Error(err.Error())
+				}
+			}
+			e.postProcess(l.fullPath)
+		}
+		e.record(l.entry, err)
+	}
+}
+
+// link 按去重方式由 src 生成 dst，无法链接时复制并返回 copied
+func (e *Exporter) link(src, dst string) (copied bool, err error) {
+	if err := os.Remove(dst); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return false, err
+	}
+
+	switch e.dedupe.mode {
+	case DedupeHardlink:
+		err = os.Link(src, dst)
+	case DedupeReflink:
+		err = reflink(src, dst)
+	case DedupeSymlink:
+		// 使用相对路径，移动下载目录后链接仍然有效
+		var target string
+		if target, err = filepath.Rel(filepath.Dir(dst), src); err == nil {
+			err = os.Symlink(target, dst)
+		}
+	}
+	if err == nil {
+		return false, nil
+	}
+
+	global.Log.Warn("无法以 %s 生成 %s，改为复制: %v", e.dedupe.mode, dst, err)
+	return true, copyFile(src, dst)
+}
+
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
+
+// printDedupe 输出去重汇总
+func (e *Exporter) printDedupe() {
+	s := e.dedupe.summary
+	e.manifest.Dedupe = &s
+	if s.Files == 0 {
+		return
+	}
+	display.Progress("♻️ 去重: %d 个重复文件以 %s 生成，节省下载 %s", s.Files, s.Mode, display.FormatBytes(s.SavedBytes))
+	if s.Copied > 0 {
+		display.Progress("⚠️ 其中 %d 个文件无法链接，已改为复制", s.Copied)
+	}
+}
@@ -0,0 +1,260 @@
+package kdocs
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"KingExporter/internal/global"
+	"KingExporter/internal/services/api"
+	"github.com/samber/lo"
+)
+
+// Handler 决定一类云文件如何导出到本地。
+//
+// processFile 按顺序查找第一个 Match 的 Handler，由其调用 HandleContext 投递下载或转码任务。
+// 通过 ExportOptions.Handlers 注册的 Handler 优先于内置 Handler。
+// 回收站中的文件同样由 Handler 导出，此时 HandleContext.Trash 不为空。
+type Handler interface {
+	// Match 判断文件是否由该 Handler 处理
+	Match(f api.File) bool
+	// Handle 为文件投递下载或转码任务
+	Handle(ctx *HandleContext) error
+}
+
+// HandleContext 提供 Handler 处理单个文件所需的信息及投递任务的方法
+type HandleContext struct {
+	API     *api.KDocsApi
+	File    api.File
+	GroupID int
+	// Convert 为在线文档的转码格式
+	Convert ConvertMap
+	// Trash 不为空时文件位于回收站，直接下载需通过 API.GetTrashDownloadUrl 获取下载地址
+	Trash *api.TrashFile
+
+	e   *Exporter
+	st  *state
+	dir location
+	// paths 记录已投递任务的本地路径
+	paths []string
+}
+
+// Path 返回 name 在本地的完整路径，name 按文件名规则清理，与同目录文件重名时追加后缀
+func (c *HandleContext) Path(name string) string {
+	return filepath.Join(c.st.downloadDir, c.dir.local, c.e.names.localName(name, c.dir.suffixes[c.File.ID], false))
+}
+
+// Download 将 url 下载到与云文件同目录的 name
+func (c *HandleContext) Download(name, url string) error {
+	if !c.planned(exportTarget{Name: name}.target()) {
+		return nil
+	}
+	fullPath, err := c.prepare(name)
+	if err != nil {
+		return err
+	}
+	entry := c.entry(name, fullPath)
+	target := exportTarget{Name: name}.target()
+	if c.reuse(entry, target) || c.dedupe(entry, fullPath, target) {
+		return nil
+	}
+	c.st.downloadWg.Add(1)
+	c.st.downloadCh <- DownloadJob{
+		Url:      url,
+		FullPath: fullPath,
+		Entry:    entry,
+		File:     c.File,
+	}
+	return nil
+}
+
+// ConvertTo 通过预导出接口将云文件转码为 format 并保存为 name
+func (c *HandleContext) ConvertTo(name, format string) error {
+	if !c.planned(format) {
+		return nil
+	}
+	fullPath, err := c.prepare(name)
+	if err != nil {
+		return err
+	}
+	entry := c.entry(name, fullPath)
+	if c.reuse(entry, format) || c.dedupe(entry, fullPath, format) {
+		return nil
+	}
+	c.st.preloadWg.Add(1)
+	c.st.preloadCh <- PreloadJob{
+		File:       c.File,
+		GroupID:    c.GroupID,
+		FullPath:   fullPath,
+		Format:     format,
+		MaxRetries: MaxRetries,
+		Entry:      entry,
+	}
+	return nil
+}
+
+// planned 判断导出计划中是否包含文件的 target 导出结果，不包含时保留镜像模式上次的导出结果
+func (c *HandleContext) planned(target string) bool {
+	scope := c.e.mirrorScope(c.st)
+	if c.e.planned(scope, c.File.ID, target) {
+		return true
+	}
+	if c.e.mirror != nil {
+		c.e.mirror.keep(scope, c.File.ID)
+	}
+	return false
+}
+
+// Fail 在导出清单中记录 name 导出失败
+func (c *HandleContext) Fail(name string, err error) {
+	c.e.record(c.entry(name, c.Path(name)), err)
+}
+
+// entry 创建 name 的清单条目，记录本地名称是否被清理或追加了重名后缀
+func (c *HandleContext) entry(name, fullPath string) ManifestEntry {
+	entry := c.e.newEntry(c.File, c.GroupID, c.dir.remote, fullPath)
+	entry.Sanitized = c.dir.sanitized || c.e.names.Clean(name) != name
+	entry.Collision = c.dir.collision || c.dir.suffixes[c.File.ID] != ""
+	if c.Trash != nil {
+		entry.OriginalPath = joinRemotePath(c.Trash.OriginalPath, c.File.FName)
+		if c.Trash.DeletedTime > 0 {
+			entry.DeletedAt = time.Unix(c.Trash.DeletedTime, 0).Format(time.RFC3339)
+		}
+	}
+	return entry
+}
+
+// downloadURL 返回 get 获取的下载地址，回收站中的文件改用回收站下载地址
+func (c *HandleContext) downloadURL(get func() (string, error)) (string, error) {
+	if c.Trash != nil {
+		item, err := c.API.GetTrashDownloadUrl(c.GroupID, c.File.ID)
+		if err != nil {
+			return "", err
+		}
+		return item.Url, nil
+	}
+	return get()
+}
+
+func (c *HandleContext) prepare(name string) (string, error) {
+	fullPath := c.Path(name)
+	if !withinDir(c.st.downloadDir, fullPath) {
+		return "", fmt.Errorf("本地路径 %s 超出导出目录", fullPath)
+	}
+	dirPath := filepath.Dir(fullPath)
+	if err := c.e.mkdirAll(dirPath); err != nil {
+		return "", err
+	}
+	c.paths = append(c.paths, fullPath)
+	return fullPath, nil
+}
+
+// handlerFunc 将两个函数组合为 Handler
+type handlerFunc struct {
+	match  func(f api.File) bool
+	handle func(ctx *HandleContext) error
+}
+
+func (h handlerFunc) Match(f api.File) bool           { return h.match(f) }
+func (h handlerFunc) Handle(ctx *HandleContext) error { return h.handle(ctx) }
+
+// NewHandler 使用 match 及 handle 函数创建自定义 Handler
+func NewHandler(match func(f api.File) bool, handle func(ctx *HandleContext) error) Handler {
+	return handlerFunc{match: match, handle: handle}
+}
+
+// MatchExt 返回按扩展名匹配文件的函数
+func MatchExt(exts ...string) func(f api.File) bool {
+	return func(f api.File) bool {
+		return lo.Contains(exts, filepath.Ext(f.FName))
+	}
+}
+
+// DirectHandler 通过 Office 下载地址直接下载文件
+func DirectHandler(exts ...string) Handler {
+	return NewHandler(MatchExt(exts...), func(ctx *HandleContext) error {
+		url, err := ctx.downloadURL(func() (string, error) {
+			item, err := ctx.API.GetDownloadUrl(ctx.File.ID)
+			if err != nil {
+				return "", err
+			}
+			return item.Url, nil
+		})
+		if err != nil {
+			global.Log.Error(ctx.e.logError("获取文件见地址失败", err, ctx.File, ctx.GroupID))
+			ctx.Fail(ctx.File.FName, err)
+			return err
+		}
+		return ctx.Download(ctx.File.FName, url)
+	})
+}
+
+// PDFHandler 通过 group 文件下载地址下载 PDF
+func PDFHandler() Handler {
+	return NewHandler(MatchExt(".pdf"), downloadFromGroup("获取 PDF 下载地址失败"))
+}
+
+// GenericHandler 通过 group 文件下载地址下载任意文件，默认不注册，可通过 ExportOptions.Handlers 启用
+func GenericHandler() Handler {
+	return NewHandler(func(f api.File) bool { return f.FType != "folder" }, downloadFromGroup("获取文件下载地址失败"))
+}
+
+func downloadFromGroup(msg string) func(ctx *HandleContext) error {
+	return func(ctx *HandleContext) error {
+		url, err := ctx.downloadURL(func() (string, error) {
+			item, err := ctx.API.GetPDFDownloadUrl(ctx.GroupID, ctx.File.ID)
+			if err != nil {
+				return "", err
+			}
+			return item.Url, nil
+		})
+		if err != nil {
+			global.Log.Error(ctx.e.logError(msg, err, ctx.File, ctx.GroupID))
+			ctx.Fail(ctx.File.FName, err)
+			return err
+		}
+		return ctx.Download(ctx.File.FName, url)
+	}
+}
+
+// ConvertHandler 通过预导出接口将在线文档转码为 ConvertMap 指定的格式
+func ConvertHandler() Handler {
+	return NewHandler(isNative, func(ctx *HandleContext) error {
+		for _, t := range exportTargets(ctx.File, ctx.Convert) {
+			if err := ctx.ConvertTo(t.Name, t.Format); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// builtinHandlers 是默认注册的 Handler
+func builtinHandlers() []Handler {
+	return []Handler{
+		PDFHandler(),
+		DirectHandler(lo.Without(directExts, ".pdf")...),
+		ConvertHandler(),
+	}
+}
+
+func (e *Exporter) handlerFor(f api.File) Handler {
+	h, _ := lo.Find(e.handlers, func(h Handler) bool { return h.Match(f) })
+	return h
+}
+
+// handle 由匹配的 Handler 处理 ctx.File，没有匹配的 Handler 时不导出。
+// 处理失败时保留镜像模式上次的导出结果
+func (e *Exporter) handle(ctx *HandleContext) error {
+	h := e.handlerFor(ctx.File)
+	if h == nil {
+		return nil
+	}
+	if err := h.Handle(ctx); err != nil {
+		if e.mirror != nil {
+			e.mirror.keep(e.mirrorScope(ctx.st), ctx.File.ID)
+		}
+		return err
+	}
+	return nil
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"os"
+	"path/filepath"
 
 	"KingExporter/internal/global"
 	"KingExporter/internal/services/api"
@@ -14,7 +15,7 @@ import (
 func (e *Exporter) scanInput(target *string) {
 	_, err := fmt.Scanln(target)
 	if err != nil {
-		global.Log.Error("获取用户输入失败", err)
+		global.Log.Error("获取用户输入失败: %v", err)
 	}
 }
 
@@ -67,7 +68,7 @@ func (e *Exporter) setupDownloadDirectory() error {
 		defaultDir, err := e.getTempDir()
 		tip := "获取临时文件夹失败，请输入下载地址"
 		if err != nil {
-			global.Log.Warn("获取临时文件夹失败", err)
+			global.Log.Warn("获取临时文件夹失败: %v", err)
 		} else {
 			tip = fmt.Sprintf("请输入下载地址 (%s)", defaultDir)
 		}
@@ -84,6 +85,76 @@ func (e *Exporter) setupDownloadDirectory() error {
 	return e.validateDirectory()
 }
 
+// setupDestination 创建导出结果的写入位置。写入归档、对象存储等 Sink 时不使用下载目录，
+// downloadDir 仅作为计算相对路径的根
+func (e *Exporter) setupDestination() error {
+	switch {
+	case e.sink != nil:
+		// 通过 ExportOptions.Sink 指定
+		e.downloadDir, e.location = ".", fmt.Sprintf("%T", e.sink)
+		if s, ok := e.sink.(fmt.Stringer); ok {
+			e.location = s.String()
+		}
+
+	case e.outputArchive != "" && e.destination != "" && e.destination != DestinationLocal:
+		return errors.New("归档文件与其他导出目的地不能同时指定")
+
+	case e.outputArchive != "":
+		if _, err := archiveExt(e.outputArchive); err != nil {
+			return fmt.Errorf("设置归档文件失败: %w", err)
+		}
+		if f, err := os.Stat(filepath.Dir(e.outputArchive)); err != nil || !f.IsDir() {
+			return fmt.Errorf("设置归档文件失败: 所在目录不存在 %s", filepath.Dir(e.outputArchive))
+		}
+		a, err := newArchive(e.outputArchive)
+		if err != nil {
+			return fmt.Errorf("设置归档文件失败: %w", err)
+		}
+		e.sink, e.downloadDir, e.location = a, ".", e.outputArchive
+
+	case isS3URL(e.destination):
+		d, err := newS3Sink(e.destination, e.s3)
+		if err != nil {
+			return fmt.Errorf("设置对象存储失败: %w", err)
+		}
+		e.sink, e.downloadDir, e.location = d, ".", e.destination
+
+	case isWebDAVURL(e.destination):
+		d, err := newWebDAVSink(e.destination)
+		if err != nil {
+			return fmt.Errorf("设置 WebDAV 失败: %w", err)
+		}
+		// 展示时不包含 URL 中的密码
+		e.sink, e.downloadDir, e.location = d, ".", d.base
+
+	case e.destination != "" && e.destination != DestinationLocal:
+		return fmt.Errorf("不支持的导出目的地 %s，目前支持 s3://bucket/prefix 及 davs://host/path", e.destination)
+
+	default:
+		if err := e.setupDownloadDirectory(); err != nil {
+			return fmt.Errorf("设置云文件下载目录失败: %w", err)
+		}
+		e.sink, e.location = NewLocalSink(e.downloadDir), e.downloadDir
+	}
+	return nil
+}
+
+// setupMirror 读取下载目录中上次导出的镜像状态，镜像模式只支持本地下载目录
+func (e *Exporter) setupMirror() error {
+	if !e.mirrorEnabled {
+		return nil
+	}
+	if _, ok := e.sink.(*localSink); !ok {
+		return errors.New("镜像模式只支持导出到本地下载目录")
+	}
+	m, err := loadMirror(e.downloadDir, e.mirrorOrphans, e.mirrorAllowMassDelete, e.postProcessOutputs, e.postProcess)
+	if err != nil {
+		return err
+	}
+	e.mirror = m
+	return nil
+}
+
 // validateDirectory ensures the download directory exists and is actually a directory
 func (e *Exporter) validateDirectory() error {
 	for {
@@ -110,22 +181,80 @@ func (e *Exporter) validateDirectory() error {
 	return nil
 }
 
-func (e *Exporter) Check() {
+// checkOptions 检查转码参数、文件名规则及会话 ID
+func (e *Exporter) checkOptions() {
+	if err := e.convert.Validate(); err != nil {
+		err = fmt.Errorf("转码参数不合法: %w", err)
+		global.Log.Error(err.Error())
+		display.Exit(1, err.Error())
+	}
+
+	if err := e.names.Validate(); err != nil {
+		global.Log.Error(err.Error())
+		display.Exit(1, err.Error())
+	}
+
 	if err := e.validateSID(); err != nil {
 		err = fmt.Errorf("获取会话信息失败: %w", err)
 		global.Log.Error(err.Error())
 		display.Exit(1, err.Error())
 	}
+}
 
-	if err := e.setupDownloadDirectory(); err != nil {
-		err = fmt.Errorf("设置云文件下载目录失败: %w", err)
+// checkSync 设置去重及镜像模式，需在导出目的地之后设置
+func (e *Exporter) checkSync() {
+	if e.dedupeMode != "" {
+		if err := ValidateDedupe(e.dedupeMode); err != nil {
+			global.Log.Error(err.Error())
+			display.Exit(1, err.Error())
+		}
+		if _, ok := e.sink.(*localSink); !ok {
+			err := errors.New("去重只支持导出到本地下载目录")
+			global.Log.Error(err.Error())
+			display.Exit(1, err.Error())
+		}
+		e.dedupe = newDedupe(e.dedupeMode)
+	}
+
+	if err := e.setupMirror(); err != nil {
+		err = fmt.Errorf("设置镜像模式失败: %w", err)
 		global.Log.Error(err.Error())
 		display.Exit(1, err.Error())
 	}
+}
 
+func (e *Exporter) checkUserAccess() {
 	if err := e.validateUserAccess(); err != nil {
 		err = fmt.Errorf("获取用户信息失败: %w", err)
 		global.Log.Error(err.Error())
 		display.Exit(1, err.Error())
 	}
 }
+
+func (e *Exporter) Check() {
+	e.checkOptions()
+	if err := e.setupDestination(); err != nil {
+		global.Log.Error(err.Error())
+		display.Exit(1, err.Error())
+	}
+	e.checkSync()
+	e.checkUserAccess()
+}
+
+// checkPlanner 检查生成导出计划所需的参数。下载目录只用于读取镜像状态，不创建导出目的地
+func (e *Exporter) checkPlanner() {
+	e.checkOptions()
+	if e.downloadDir == "" {
+		if e.mirrorEnabled {
+			display.Exit(1, "镜像模式下请通过 --download_dir 指定上次导出的目录")
+		}
+		e.downloadDir = "."
+	} else if err := e.validateDirectory(); err != nil {
+		err = fmt.Errorf("设置云文件下载目录失败: %w", err)
+		global.Log.Error(err.Error())
+		display.Exit(1, err.Error())
+	}
+	e.sink, e.location = NewLocalSink(e.downloadDir), e.downloadDir
+	e.checkSync()
+	e.checkUserAccess()
+}
@@ -7,12 +7,21 @@ import (
 type DownloadJob struct {
 	Url      string
 	FullPath string
+	Entry    ManifestEntry
+	// File 用于在下载完成后写入元数据及恢复修改时间
+	File api.File
 }
 
 type PreloadJob struct {
-	File       api.File
-	GroupID    int
-	FullPath   string
+	File     api.File
+	GroupID  int
+	FullPath string
+	// Format 转码的目标格式，例如 docx、pdf
+	Format     string
 	RetryCount int
 	MaxRetries int
+	Entry      ManifestEntry
+	// TaskID、TaskType 为已创建的转码任务，重试时复用
+	TaskID   string
+	TaskType string
 }
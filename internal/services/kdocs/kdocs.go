@@ -6,11 +6,11 @@ import (
 	"path"
 	"path/filepath"
 	"sync"
+	"time"
 
 	"KingExporter/internal/global"
 	"KingExporter/internal/services/api"
 	"KingExporter/pkg/display"
-	"KingExporter/pkg/utils"
 	"github.com/go-resty/resty/v2"
 	"github.com/samber/lo"
 	"github.com/spf13/cast"
@@ -34,15 +34,64 @@ type state struct {
 	preloadWg   *sync.WaitGroup
 	workerWg    *sync.WaitGroup
 	downloadDir string
+	folders     []folderMeta
+	permissions *groupPermissions
+	// links 为等待源文件导出后生成的重复文件
+	links []dedupeLink
 }
 
 type Exporter struct {
-	silent      bool
-	downloadDir string
-	sid         string
-	api         *api.KDocsApi
-	exportAll   bool
-	groupID     int
+	silent          bool
+	downloadDir     string
+	sid             string
+	api             *api.KDocsApi
+	selector        GroupSelector
+	remotePath      string
+	folderID        int
+	fileID          int
+	includeShared   bool
+	includeStarred  bool
+	includeRecent   bool
+	includeTrash    bool
+	allVersions     bool
+	versionsLayout  string
+	writeMeta       bool
+	withComments    bool
+	withPermissions bool
+	convert         ConvertMap
+	handlers        []Handler
+	// preloadMaxTimeout 单次转码等待时间的上限
+	preloadMaxTimeout time.Duration
+	postProcessors    []PostProcessor
+	// outputArchive 不为空时导出结果写入该归档文件
+	outputArchive string
+	// destination 为对象存储或 WebDAV 地址
+	destination string
+	s3          S3Options
+	// sink 为导出结果的写入位置，location 为展示给用户的导出位置
+	sink     Sink
+	location string
+	// mirror 不为空时按文件 ID 同步本地下载目录
+	mirror                *mirror
+	mirrorEnabled         bool
+	mirrorOrphans         string
+	mirrorAllowMassDelete bool
+	// dedupe 不为空时相同内容的文件只下载一次
+	dedupe     *dedupe
+	dedupeMode string
+	// names 为云文件名转换为本地文件名的规则
+	names NameRules
+	// budget 不为空时限制总下载量
+	budget *budget
+	// checkSpace 为 true 时导出前检查剩余空间
+	checkSpace bool
+	// listings 为规划阶段获取的目录列表
+	listings *listings
+	// fromPlan 不为空时按导出计划导出，allowed 为计划中的导出结果
+	fromPlan *Plan
+	allowed  map[string]bool
+
+	manifest *Manifest
 }
 
 type ExportOptions struct {
@@ -50,18 +99,146 @@ type ExportOptions struct {
 	SilentMode  bool
 	ExportAll   bool
 	GroupID     int
+	// Groups 选择需要导出的 group，ExportAll 与 GroupID 会合并到其中
+	Groups GroupSelector
+	// Path 只导出 group 中的指定远程路径，例如 /项目A/设计
+	Path string
+	// FolderID 只导出指定的文件夹，忽略 group 选择
+	FolderID int
+	// FileID 只导出指定的单个文件，忽略 group 选择
+	FileID int
+	// IncludeShared、IncludeStarred、IncludeRecent 额外导出"与我共享"、"星标"、"最近"列表
+	IncludeShared  bool
+	IncludeStarred bool
+	IncludeRecent  bool
+	// IncludeTrash 同时导出各 group 回收站中的文件到 .trash/ 目录
+	IncludeTrash bool
+	// AllVersions 同时下载每个文件的所有历史版本，VersionsLayout 为 suffix 或 dir
+	AllVersions    bool
+	VersionsLayout string
+	// WriteMeta 为每个文件及文件夹写入 <name>.meta.json 元数据
+	WriteMeta bool
+	// WithComments 同时导出文档的评论到 <name>.comments.json 及 <name>.comments.md
+	WithComments bool
+	// WithPermissions 收集 group 成员及文件的分享链接、协作者，写入每个 group 目录下的 permissions.json
+	WithPermissions bool
+	// Convert 指定在线文档的转码格式，未指定的类型使用默认格式
+	Convert ConvertMap
+	// Handlers 自定义文件类型的处理方式，优先于内置 Handler 匹配
+	Handlers []Handler
+	// PreloadTimeout 单次转码等待时间的上限，实际等待时间按文件大小计算，默认 10 分钟
+	PreloadTimeout time.Duration
+	// PostProcessors 在每个文件下载完成后执行，例如 MarkdownProcessor
+	PostProcessors []PostProcessor
+	// OutputArchive 将导出结果直接写入 .zip 或 .tar.gz 文件，不在下载目录中保留文件
+	OutputArchive string
+	// Destination 为导出目的地，local（默认）写入下载目录，s3://bucket/prefix 直接上传到对象存储，
+	// dav(s)://host/path 通过 WebDAV 上传，例如 Nextcloud
+	Destination string
+	// S3 配置对象存储的地址及区域
+	S3 S3Options
+	// Sink 自定义导出结果的写入位置，设置后忽略 DownloadDir、OutputArchive 及 Destination
+	Sink Sink
+	// Mirror 按上次导出记录的文件 ID 同步下载目录：云端重命名或移动的文件在本地移动，未变化的文件不再下载，
+	// 云端已删除的文件按 MirrorOrphans（move 或 delete，默认 move）移动到 .orphaned/ 或删除。
+	// 一个 group 中超过一半的文件在云端消失时不清理，除非设置 MirrorAllowMassDelete
+	Mirror                bool
+	MirrorOrphans         string
+	MirrorAllowMassDelete bool
+	// Dedupe 为 hardlink、reflink 或 symlink 时，一次导出中校验和相同的文件（没有校验和时为同一文件 ID）只下载第一份，
+	// 其余以该方式生成，无法链接时复制
+	Dedupe string
+	// Names 控制云文件名转换为本地文件名的规则，默认同时兼容 Windows、macOS 及 Linux
+	Names NameRules
+	// MaxTotalSize 大于 0 时，总下载量达到该字节数后停止下载，其余文件在清单中记为 skipped
+	MaxTotalSize int64
+	// CheckSpace 在导出前遍历所有待导出的文件，估算下载量并与本地剩余空间比较，空间不足时拒绝导出。
+	// 设置 MaxTotalSize 时同样检查
+	CheckSpace bool
+	// Plan 不为空时执行 KingExporter plan 保存的导出计划：导出范围、转码格式及文件名规则取自计划，
+	// 只使用计划中的列表，不再遍历远程目录，计划中已删除的条目不导出
+	Plan *Plan
 }
 
 func NewExporter(sid string, options ExportOptions) *Exporter {
+	e := newExporter(sid, options)
+	e.Check()
+	return e
+}
+
+// NewPlanner 返回只用于生成导出计划的 Exporter，不创建导出目的地。
+// 指定 DownloadDir 时按其中的镜像状态及去重方式标记无需下载的文件
+func NewPlanner(sid string, options ExportOptions) *Exporter {
+	e := newExporter(sid, options)
+	e.checkPlanner()
+	return e
+}
+
+func newExporter(sid string, options ExportOptions) *Exporter {
+	selector := options.Groups
+	selector.All = selector.All || options.ExportAll
+	if options.GroupID > 0 {
+		selector.IDs = append(selector.IDs, options.GroupID)
+	}
+
+	preloadTimeout := options.PreloadTimeout
+	if preloadTimeout <= 0 {
+		preloadTimeout = DefaultPreloadTimeout
+	}
+
 	e := &Exporter{
-		silent:      options.SilentMode,
-		downloadDir: options.DownloadDir,
-		groupID:     options.GroupID,
-		exportAll:   options.ExportAll,
-		sid:         sid,
+		silent:                options.SilentMode,
+		downloadDir:           options.DownloadDir,
+		selector:              selector,
+		remotePath:            options.Path,
+		folderID:              options.FolderID,
+		fileID:                options.FileID,
+		sid:                   sid,
+		includeShared:         options.IncludeShared,
+		includeStarred:        options.IncludeStarred,
+		includeRecent:         options.IncludeRecent,
+		includeTrash:          options.IncludeTrash,
+		allVersions:           options.AllVersions,
+		versionsLayout:        options.VersionsLayout,
+		writeMeta:             options.WriteMeta,
+		withComments:          options.WithComments,
+		withPermissions:       options.WithPermissions,
+		convert:               options.Convert,
+		handlers:              append(append([]Handler{}, options.Handlers...), builtinHandlers()...),
+		preloadMaxTimeout:     preloadTimeout,
+		postProcessors:        options.PostProcessors,
+		outputArchive:         options.OutputArchive,
+		destination:           options.Destination,
+		s3:                    options.S3,
+		sink:                  options.Sink,
+		mirrorEnabled:         options.Mirror,
+		mirrorOrphans:         options.MirrorOrphans,
+		mirrorAllowMassDelete: options.MirrorAllowMassDelete,
+		dedupeMode:            options.Dedupe,
+		names:                 options.Names,
+		checkSpace:            options.CheckSpace,
+		listings:              newListings(),
+		manifest:              NewManifest(),
+	}
+	if options.MaxTotalSize > 0 {
+		e.budget = newBudget(options.MaxTotalSize)
+	}
+	if p := options.Plan; p != nil {
+		e.fromPlan, e.allowed = p, p.allowed()
+		e.selector = GroupSelector{All: p.Options.All}
+		e.remotePath, e.folderID, e.fileID = p.Options.Path, p.Options.FolderID, p.Options.FileID
+		e.includeShared, e.includeStarred = p.Options.IncludeShared, p.Options.IncludeStarred
+		e.includeRecent, e.includeTrash = p.Options.IncludeRecent, p.Options.IncludeTrash
+		e.convert, e.names = p.Options.Convert, p.Options.Names
+		e.listings = &listings{items: p.Listings, pinned: true}
 	}
+	e.postProcessors = lo.Map(e.postProcessors, func(p PostProcessor, _ int) PostProcessor {
+		if n, ok := p.(nameRulesUser); ok {
+			return n.withNames(e.names)
+		}
+		return p
+	})
 
-	e.Check()
 	return e
 }
 
@@ -80,17 +257,17 @@ func (e *Exporter) getTempDir() (string, error) {
 	return dir, nil
 }
 
-func (e *Exporter) exportGroup(groupID int, name ...string) {
+// run 启动转码及下载 worker，由 walk 投递任务，并等待所有任务完成。
+// permissions 不为空时收集遍历到的文件权限，结束后写入 permissions.json
+func (e *Exporter) run(downloadDir string, permissions *groupPermissions, walk func(st *state) error) error {
 	st := &state{
-		downloadCh: make(chan DownloadJob, NumWorkerDownload),
-		preloadCh:  make(chan PreloadJob, NumWorkerPreload),
-		downloadWg: &sync.WaitGroup{},
-		preloadWg:  &sync.WaitGroup{},
-		workerWg:   &sync.WaitGroup{},
-	}
-	st.downloadDir = e.downloadDir
-	if len(name) > 0 {
-		st.downloadDir = path.Join(e.downloadDir, name[0])
+		downloadCh:  make(chan DownloadJob, NumWorkerDownload),
+		preloadCh:   make(chan PreloadJob, NumWorkerPreload),
+		downloadWg:  &sync.WaitGroup{},
+		preloadWg:   &sync.WaitGroup{},
+		workerWg:    &sync.WaitGroup{},
+		downloadDir: downloadDir,
+		permissions: permissions,
 	}
 
 	for i := 0; i < NumWorkerPreload; i++ {
@@ -103,10 +280,7 @@ func (e *Exporter) exportGroup(groupID int, name ...string) {
 		go e.downloadWorker(i, st)
 	}
 
-	// DFS 遍历目录
-	if err := e.processFolder(groupID, 0, "", st); err != nil {
-		panic(err)
-	}
+	err := walk(st)
 
 	// 等待所有转码任务结束
 	st.preloadWg.Wait()
@@ -119,112 +293,274 @@ func (e *Exporter) exportGroup(groupID int, name ...string) {
 
 	// 等待所有的 worker 结束
 	st.workerWg.Wait()
+
+	if len(st.links) > 0 {
+		e.materializeLinks(st)
+	}
+	e.finalizeFolders(st)
+	if st.permissions != nil {
+		if err := e.savePermissions(st.permissions, downloadDir); err != nil {
+			global.Log.Error(err.Error())
+		}
+	}
+	return err
 }
 
-func (e *Exporter) Export() string {
+func (e *Exporter) exportGroup(groupID int, name ...string) {
+	dir := e.downloadDir
+	groupName := ""
+	if len(name) > 0 {
+		dir = e.groupDir(name[0])
+		groupName = name[0]
+	}
+
+	var permissions *groupPermissions
+	if e.withPermissions {
+		permissions = e.newGroupPermissions(groupID, groupName)
+	}
+
+	err := e.run(dir, permissions, func(st *state) error {
+		folderID, err := e.rootFolder(groupID)
+		if err != nil {
+			return err
+		}
+		dir := remoteLocation(splitRemotePath(e.remotePath), e.names)
+
+		// DFS 遍历目录
+		if err := e.processFolder(groupID, folderID, dir, st); err != nil {
+			return err
+		}
+		if e.includeTrash {
+			if err := e.processTrash(groupID, st); err != nil {
+				return err
+			}
+		}
+		// 目录及回收站都获取成功后才清理云端已删除的文件
+		if e.mirror != nil && e.remotePath == "" {
+			e.mirror.markScanned(e.mirrorScope(st))
+		}
+		return nil
+	})
+	if err != nil {
+		err = fmt.Errorf("导出 group %d 失败: %w", groupID, err)
+		global.Log.Error(err.Error())
+		display.PrintError(err.Error())
+	}
+}
+
+// groupName 返回 group 的名称，找不到时使用 group ID
+func groupName(groups []api.Group, groupID int) string {
+	if g, ok := lo.Find(groups, func(g api.Group) bool { return g.ID == groupID }); ok {
+		return g.Name
+	}
+	return cast.ToString(groupID)
+}
+
+// fileInfo 返回 --folder_id 或 --file_id 指定的文件夹或文件，获取失败时退出
+func (e *Exporter) fileInfo(fileID int) *api.File {
+	f, err := e.api.FileInfo(fileID)
+	if err != nil {
+		err = fmt.Errorf("获取文件信息失败 fileID %d: %w", fileID, err)
+		global.Log.Error(err.Error())
+		display.Exit(1, err.Error())
+	}
+	return f
+}
+
+// exportByID 导出 --folder_id 或 --file_id 指定的文件夹或文件，保留其在 group 中的相对路径
+func (e *Exporter) exportByID(groups []api.Group, f *api.File) {
+	parent, err := e.parentOf(*f)
+	if err != nil {
+		global.Log.Error(err.Error())
+		display.Exit(1, err.Error())
+	}
+
+	name := groupName(groups, f.GroupID)
+
+	var permissions *groupPermissions
+	if e.withPermissions {
+		permissions = e.newGroupPermissions(f.GroupID, name)
+	}
+
+	err = e.run(e.groupDir(name), permissions, func(st *state) error {
+		dir := remoteLocation(splitRemotePath(parent), e.names)
+		if f.FType == "folder" {
+			folder := dir.child(*f, e.names)
+			e.processFolderMeta(*f, filepath.Join(st.downloadDir, folder.local), st)
+			return e.processFolder(f.GroupID, f.ID, folder, st)
+		}
+		return e.processFile(*f, f.GroupID, dir, st)
+	})
+	if err != nil {
+		err = fmt.Errorf("导出 %s 失败: %w", f.FName, err)
+		global.Log.Error(err.Error())
+		display.PrintError(err.Error())
+	}
+}
+
+// exportScope 返回全部 group、选中的 group 及 --folder_id、--file_id 指定的文件夹或文件，从计划导出时取自计划
+func (e *Exporter) exportScope() (groups, selected []api.Group, target *api.File) {
+	if e.fromPlan != nil {
+		return e.fromPlan.Groups, e.fromPlan.Groups, e.fromPlan.Target
+	}
+
 	groups, err := e.api.GetGroups()
 	if err != nil {
 		err = fmt.Errorf("获取我的云文件及团队 group 失败: %w", err)
 		global.Log.Error(err.Error())
 		display.Exit(1, err.Error())
 	}
+	switch {
+	case e.fileID > 0:
+		target = e.fileInfo(e.fileID)
+	case e.folderID > 0:
+		target = e.fileInfo(e.folderID)
+	default:
+		if selected, err = e.selector.Select(groups); err != nil {
+			global.Log.Error(err.Error())
+			display.Exit(1, err.Error())
+		}
+	}
+	return groups, selected, target
+}
 
-	dir := e.downloadDir
-	if e.exportAll {
+func (e *Exporter) Export() string {
+	// 先确定导出范围，设置 CheckSpace 或下载上限时先遍历待导出的文件，检查剩余空间后再开始导出
+	groups, selected, target := e.exportScope()
+	if e.checkSpace || e.budget != nil {
+		e.preflight(e.plan(groups, selected, target))
+	}
+
+	dir := e.location
+	defer func() {
+		if e.budget != nil {
+			e.printBudget()
+		}
+		if e.dedupe != nil {
+			e.printDedupe()
+		}
+		if e.mirror != nil {
+			e.finishMirror()
+		}
+		if err := e.saveManifest(); err != nil {
+			global.Log.Error(err.Error())
+			display.PrintError(err.Error())
+		}
+		if err := e.sink.Close(); err != nil {
+			global.Log.Error(err.Error())
+			display.PrintError(err.Error())
+		}
+	}()
+
+	if target != nil {
+		e.exportByID(groups, target)
+		return dir
+	}
+
+	if e.selector.All {
 		wg := sync.WaitGroup{}
-		for _, v := range groups {
+		for _, v := range selected {
+			wg.Add(1)
 			go func() {
-				wg.Add(1)
-				subDir := path.Join(e.downloadDir, v.Name)
-				if err := os.MkdirAll(subDir, os.ModePerm); err != nil {
+				defer wg.Done()
+				subDir := e.groupDir(v.Name)
+				if err := e.mkdirAll(subDir); err != nil {
 					err = fmt.Errorf("创建 %s group 失败: %w", v.Name, err)
 					global.Log.Error(err.Error())
 					return
 				}
 				e.exportGroup(v.ID, v.Name)
-				fmt.Printf("✅ 团队 %s 文档导出完成\n", v.Name)
-				wg.Done()
+				display.Progress("✅ 团队 %s 文档导出完成", v.Name)
 			}()
 		}
 		wg.Wait()
-	} else if e.groupID > 0 {
-		found := false
-		for _, v := range groups {
-			if v.ID == e.groupID {
-				e.exportGroup(e.groupID, v.Name)
-				found = true
-				break
-			}
-		}
-		if !found {
-			display.Exit(1, "groupID: %d 不存在", e.groupID)
-		}
 	} else {
-		for _, v := range groups {
-			if v.Type == "special" || v.Type == "corpspecial" {
-				e.exportGroup(v.ID, v.Name)
-			}
+		for _, v := range selected {
+			e.exportGroup(v.ID, v.Name)
 		}
 	}
 
+	for _, v := range e.virtualGroups() {
+		e.exportVirtual(v)
+	}
+
 	return dir
 }
 
-func (e *Exporter) processFile(f api.File, groupID int, relativePath string, st *state) error {
-	fullPath := filepath.Join(st.downloadDir, relativePath, f.FName)
-	dirPath := filepath.Dir(fullPath)
+// processFile 导出 dir 中的文件 f
+func (e *Exporter) processFile(f api.File, groupID int, dir location, st *state) error {
+	if e.exhausted(st) {
+		e.budget.skip()
+		return nil
+	}
+	if !e.planned(e.mirrorScope(st), f.ID, "") {
+		// 从导出计划中删除的文件保留镜像模式上次的导出结果
+		if e.mirror != nil {
+			e.mirror.keep(e.mirrorScope(st), f.ID)
+		}
+		return nil
+	}
+	e.collectPermissions(f, groupID, dir.remote, st)
 
-	if err := os.MkdirAll(dirPath, 0755); err != nil {
-		return fmt.Errorf("failed to create directory %s: %v", dirPath, err)
+	ctx := &HandleContext{
+		API:     e.api,
+		File:    f,
+		GroupID: groupID,
+		Convert: e.convert,
+		e:       e,
+		st:      st,
+		dir:     dir,
+	}
+	if err := e.handle(ctx); err != nil {
+		return err
+	}
+	if len(ctx.paths) == 0 {
+		return nil
 	}
 
-	ext := filepath.Ext(f.FName)
-	if lo.Contains([]string{".docx", ".pptx", ".doc", ".ppt", ".xls", ".xlsx"}, ext) {
-		item, err := e.api.GetDownloadUrl(f.ID)
-		if err != nil {
-			global.Log.Error(e.logError("获取文件见地址失败", err, f, groupID))
-			return err
-		}
-		st.downloadWg.Add(1)
-		st.downloadCh <- DownloadJob{Url: item.Url, FullPath: fullPath}
-	} else if ext == ".pdf" {
-		item, err := e.api.GetPDFDownloadUrl(groupID, f.ID)
-		if err != nil {
-			global.Log.Error(e.logError("获取 PDF 下载地址失败", err, f, groupID))
-			return err
+	if e.withComments {
+		if err := e.processComments(f, groupID, ctx.paths[0]); err != nil {
+			global.Log.Error(e.logError("处理评论失败", err, f, groupID))
 		}
-		st.downloadWg.Add(1)
-		st.downloadCh <- DownloadJob{Url: item.Url, FullPath: fullPath}
-	} else if lo.Contains([]string{".otl", ".ksheet"}, ext) {
-		fullPath = lo.If(ext == ".otl", utils.ReplaceExt(fullPath, ".docx")).Else(utils.ReplaceExt(fullPath, ".xlsx"))
-		st.preloadWg.Add(1)
-		st.preloadCh <- PreloadJob{
-			File:       f,
-			GroupID:    groupID,
-			FullPath:   fullPath,
-			RetryCount: 0,
-			MaxRetries: MaxRetries,
+	}
+
+	if e.allVersions {
+		if err := e.processVersions(f, groupID, dir, st); err != nil {
+			global.Log.Error(e.logError("处理历史版本失败", err, f, groupID))
+			if e.mirror != nil {
+				e.mirror.keep(e.mirrorScope(st), f.ID)
+			}
 		}
 	}
 
 	return nil
 }
 
-func (e *Exporter) processFolder(groupID int, folderID int, relativePath string, st *state) error {
-	files, err := e.api.Files(groupID, folderID)
+// processFolder 导出 folderID 中的文件，dir 为该文件夹的位置
+func (e *Exporter) processFolder(groupID int, folderID int, dir location, st *state) error {
+	if e.exhausted(st) {
+		return nil
+	}
+	files, err := e.files(groupID, folderID)
 	if err != nil {
 		return fmt.Errorf("获取目录文件失败 folderID %d: %v", folderID, err)
 	}
 
+	dir = e.resolveNames(dir, files)
 	for _, file := range files {
 		if file.FType == "folder" {
-			newPath := filepath.Join(relativePath, file.FName)
-			if err := e.processFolder(groupID, file.ID, newPath, st); err != nil {
+			sub := dir.child(file, e.names)
+			e.processFolderMeta(file, filepath.Join(st.downloadDir, sub.local), st)
+			e.collectPermissions(file, groupID, dir.remote, st)
+			if err := e.processFolder(groupID, file.ID, sub, st); err != nil {
 				global.Log.Error(fmt.Sprintf("处理文件夹失败 %s: %v", file.FName, err))
+				if e.mirror != nil {
+					e.mirror.markIncomplete(e.mirrorScope(st))
+				}
 				continue
 			}
 		} else {
-			if err := e.processFile(file, groupID, relativePath, st); err != nil {
+			if err := e.processFile(file, groupID, dir, st); err != nil {
 				global.Log.Error(fmt.Sprintf("处理文件失败 %s: %v", file.FName, err))
 				continue
 			}
@@ -246,15 +582,30 @@ func (e *Exporter) downloadWorker(id int, st *state) {
 			resp, err := resty.New().R().Head(job.Url)
 			if err != nil {
 				err = fmt.Errorf("[Download #[%d] Failed to get download info: %w", id, err)
-				global.Log.Error("查看下载信息失败", err.Error())
+				global.Log.Error("查看下载信息失败: %s", err.Error())
 			}
 			size := cast.ToInt64(resp.Header().Get("Content-Length"))
+			if size <= 0 {
+				size = int64(job.File.FSize)
+			}
+			if e.budget != nil && !e.budget.reserve(size) {
+				e.record(job.Entry, errBudgetExceeded)
+				st.downloadWg.Done()
+				continue
+			}
 			slow := lo.If(size > 100<<20, "⚠️ slow").Else("")
-			fmt.Printf("⏬ Downloading to %s fileSize: %s %s\n", job.FullPath, display.FormatBytes(size), slow)
-			_, err = resty.New().R().SetOutput(job.FullPath).Get(job.Url)
+			display.Progress("⏬ Downloading to %s fileSize: %s %s", job.FullPath, display.FormatBytes(size), slow)
+
+			err = e.fetch(job)
 			if err != nil {
 				global.Log.Error(fmt.Sprintf("[Download #%d] Failed to download %s to %s: %s", id, job.Url, job.FullPath, err.Error()))
+				if e.budget != nil {
+					e.budget.release(size)
+				}
+			} else {
+				e.finalizeFile(job.FullPath, job.File)
 			}
+			e.record(job.Entry, err)
 			st.downloadWg.Done()
 		}
 	}
@@ -0,0 +1,142 @@
+package kdocs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"KingExporter/internal/services/api"
+)
+
+const ManifestFileName = "manifest.json"
+
+const (
+	StatusOK     = "ok"
+	StatusFailed = "failed"
+	// StatusSkipped 表示达到下载上限后未下载
+	StatusSkipped = "skipped"
+)
+
+// ManifestEntry 记录一个云文件的来源与导出结果
+type ManifestEntry struct {
+	GroupID    int    `json:"group_id"`
+	FileID     int    `json:"file_id"`
+	RemotePath string `json:"remote_path"`
+	// LocalPath 相对于下载目录的路径
+	LocalPath string `json:"local_path"`
+	Size      int    `json:"size"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+
+	// 回收站中的文件记录其删除前的路径及删除时间
+	OriginalPath string `json:"original_path,omitempty"`
+	DeletedAt    string `json:"deleted_at,omitempty"`
+
+	// 历史版本记录版本号、修改人及修改时间
+	Version       int    `json:"version,omitempty"`
+	VersionAuthor string `json:"version_author,omitempty"`
+	VersionTime   string `json:"version_time,omitempty"`
+
+	// DedupeOf 为去重时相同内容的源文件路径
+	DedupeOf string `json:"dedupe_of,omitempty"`
+
+	// Sanitized 表示本地路径中有名称按文件名规则清理、规范化或缩短过，Collision 表示追加了重名后缀，
+	// 此时 LocalPath 与 RemotePath 中的名称不同，RemotePath 始终为完整的远程路径
+	Sanitized bool `json:"sanitized,omitempty"`
+	Collision bool `json:"collision,omitempty"`
+}
+
+// Manifest 汇总一次导出的所有文件，并发安全
+type Manifest struct {
+	mu        sync.Mutex
+	CreatedAt time.Time       `json:"created_at"`
+	Entries   []ManifestEntry `json:"entries"`
+	// Mirror 为镜像模式下对本地文件的改动
+	Mirror *MirrorSummary `json:"mirror,omitempty"`
+	// Dedupe 为去重的汇总
+	Dedupe *DedupeSummary `json:"dedupe,omitempty"`
+}
+
+func NewManifest() *Manifest {
+	return &Manifest{CreatedAt: time.Now()}
+}
+
+func (m *Manifest) Add(entry ManifestEntry) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.Entries = append(m.Entries, entry)
+}
+
+// Marshal 将清单序列化为 JSON
+func (m *Manifest) Marshal() ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	data, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("序列化导出清单失败: %w", err)
+	}
+	return data, nil
+}
+
+// Save 将清单写入 dir 下的 manifest.json
+func (m *Manifest) Save(dir string) error {
+	data, err := m.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(dir, ManifestFileName), data, 0644); err != nil {
+		return fmt.Errorf("写入导出清单失败: %w", err)
+	}
+	return nil
+}
+
+// saveManifest 将清单写入下载目录，输出为归档时写入归档根目录
+func (e *Exporter) saveManifest() error {
+	data, err := e.manifest.Marshal()
+	if err != nil {
+		return err
+	}
+	if err := e.writeFile(filepath.Join(e.downloadDir, ManifestFileName), data); err != nil {
+		return fmt.Errorf("写入导出清单失败: %w", err)
+	}
+	return nil
+}
+
+// newEntry 根据云文件及本地路径创建清单条目，remoteDir 为云文件所在的远程目录
+func (e *Exporter) newEntry(f api.File, groupID int, remoteDir, fullPath string) ManifestEntry {
+	localPath, err := filepath.Rel(e.downloadDir, fullPath)
+	if err != nil {
+		localPath = fullPath
+	}
+	return ManifestEntry{
+		GroupID:    groupID,
+		FileID:     f.ID,
+		RemotePath: joinRemotePath("/"+remoteDir, f.FName),
+		LocalPath:  filepath.ToSlash(localPath),
+		Size:       f.FSize,
+	}
+}
+
+// record 写入导出结果，err 不为空时记为失败
+func (e *Exporter) record(entry ManifestEntry, err error) {
+	entry.Status = StatusOK
+	if err != nil {
+		entry.Status = StatusFailed
+		if errors.Is(err, errBudgetExceeded) {
+			entry.Status = StatusSkipped
+		}
+		entry.Error = err.Error()
+	}
+	e.manifest.Add(entry)
+	if e.mirror != nil && err == nil {
+		e.mirror.commit(entry.LocalPath)
+	}
+	if e.dedupe != nil {
+		e.dedupe.resolve(entry.LocalPath, err == nil)
+	}
+}
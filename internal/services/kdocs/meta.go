@@ -0,0 +1,100 @@
+package kdocs
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"KingExporter/internal/global"
+	"KingExporter/internal/services/api"
+)
+
+// MetaSuffix 是元数据旁路文件的后缀
+const MetaSuffix = ".meta.json"
+
+// folderMeta 记录需要在导出结束后恢复修改时间的文件夹
+type folderMeta struct {
+	path string
+	file api.File
+}
+
+// fileMeta 是写入旁路文件的完整元数据
+type fileMeta struct {
+	ID        int           `json:"id"`
+	GroupID   int           `json:"group_id"`
+	Name      string        `json:"name"`
+	Type      string        `json:"type"`
+	Size      int           `json:"size"`
+	CreatedAt string        `json:"created_at,omitempty"`
+	UpdatedAt string        `json:"updated_at,omitempty"`
+	Creator   api.FileOwner `json:"creator"`
+	Modifier  api.FileOwner `json:"modifier"`
+}
+
+func formatUnix(sec int64) string {
+	if sec <= 0 {
+		return ""
+	}
+	return time.Unix(sec, 0).Format(time.RFC3339)
+}
+
+// writeMetaFile 在 path 旁写入 <name>.meta.json
+func (e *Exporter) writeMetaFile(path string, f api.File) error {
+	data, err := json.MarshalIndent(fileMeta{
+		ID:        f.ID,
+		GroupID:   f.GroupID,
+		Name:      f.FName,
+		Type:      f.FType,
+		Size:      f.FSize,
+		CreatedAt: formatUnix(f.CTime),
+		UpdatedAt: formatUnix(f.MTime),
+		Creator:   f.Creator,
+		Modifier:  f.Modifier,
+	}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化元数据失败: %w", err)
+	}
+	if err := e.writeFile(path+MetaSuffix, data); err != nil {
+		return fmt.Errorf("写入元数据失败 %s: %w", path, err)
+	}
+	return nil
+}
+
+// finalizeFile 在文件下载完成后写入元数据并恢复修改时间
+func (e *Exporter) finalizeFile(path string, f api.File) {
+	if e.writeMeta {
+		if err := e.writeMetaFile(path, f); err != nil {
+			global.Log.Error(err.Error())
+		}
+	}
+	if err := e.sink.Finalize(e.sinkName(path), FileInfo{Size: int64(f.FSize), ModTime: modTimeOf(f)}); err != nil {
+		global.Log.Error(err.Error())
+	}
+}
+
+// processFolderMeta 创建文件夹并登记修改时间，以便导出结束后恢复
+func (e *Exporter) processFolderMeta(f api.File, path string, st *state) {
+	if err := e.mkdirAll(path); err != nil {
+		global.Log.Error("创建文件夹失败 %s: %v", path, err)
+		return
+	}
+	if e.writeMeta {
+		if err := e.writeMetaFile(path, f); err != nil {
+			global.Log.Error(err.Error())
+		}
+	}
+	st.folders = append(st.folders, folderMeta{path: path, file: f})
+	if e.mirror != nil {
+		e.mirror.folder(e.sinkName(path))
+	}
+}
+
+// finalizeFolders 在所有文件写入后恢复文件夹的修改时间，子目录优先处理
+func (e *Exporter) finalizeFolders(st *state) {
+	for i := len(st.folders) - 1; i >= 0; i-- {
+		folder := st.folders[i]
+		if err := e.sink.Finalize(e.sinkName(folder.path), FileInfo{Size: -1, ModTime: modTimeOf(folder.file)}); err != nil {
+			global.Log.Error(err.Error())
+		}
+	}
+}
@@ -0,0 +1,494 @@
+package kdocs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"KingExporter/internal/global"
+	"KingExporter/pkg/display"
+)
+
+const (
+	// StateFileName 记录镜像模式上次导出的文件 ID 与本地路径的对应关系
+	StateFileName = ".kingexporter-state.json"
+	// OrphanedDir 存放远程已删除的文件
+	OrphanedDir = ".orphaned"
+
+	// 一个 scope 中超过 mirrorMaxOrphanRatio 且不少于 mirrorMinOrphans 个文件在云端消失时，
+	// 多半是接口异常而非真的删除，未设置 --mirror-allow-mass-delete 时不清理
+	mirrorMaxOrphanRatio = 0.5
+	mirrorMinOrphans     = 5
+)
+
+// sidecarSuffixes 为随导出结果一起移动的旁路文件
+var sidecarSuffixes = []string{MetaSuffix, CommentsJSONSuffix, CommentsMarkdownSuffix}
+
+// 远程已删除文件的处理方式
+const (
+	OrphansMove   = "move"
+	OrphansDelete = "delete"
+)
+
+// mirrorEntry 记录一个云文件的一个导出结果
+type mirrorEntry struct {
+	// Scope 为 group 或虚拟 group 的目录，不同 scope 中的同一文件分别记录
+	Scope  string `json:"scope"`
+	FileID int    `json:"file_id"`
+	// Target 区分同一文件的多个导出结果，直接下载为扩展名，转码为目标格式
+	Target string `json:"target"`
+	// Path 相对于下载目录的路径
+	Path  string `json:"path"`
+	MTime int64  `json:"mtime"`
+}
+
+func (m mirrorEntry) key() string {
+	return m.Scope + "/" + strconv.Itoa(m.FileID) + "/" + m.Target
+}
+
+func (m mirrorEntry) file() string {
+	return m.Scope + "/" + strconv.Itoa(m.FileID)
+}
+
+type mirrorState struct {
+	UpdatedAt time.Time     `json:"updated_at"`
+	Entries   []mirrorEntry `json:"entries"`
+}
+
+// MirrorMove 记录一次本地移动
+type MirrorMove struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// MirrorSummary 汇总镜像模式对本地文件的改动
+type MirrorSummary struct {
+	// Moved 为云端重命名或移动后在本地移动的文件
+	Moved []MirrorMove `json:"moved,omitempty"`
+	// Unchanged 为未变化而跳过下载的文件数
+	Unchanged int `json:"unchanged"`
+	// Orphaned 为云端已删除的文件，按 OrphanAction 移动到 .orphaned/ 或删除
+	Orphaned     []string `json:"orphaned,omitempty"`
+	OrphanAction string   `json:"orphan_action"`
+	// Incomplete 为遍历不完整、未清理已删除文件的目录
+	Incomplete []string `json:"incomplete,omitempty"`
+	// Refused 为云端消失的文件过多、未清理已删除文件的目录
+	Refused []string `json:"refused,omitempty"`
+}
+
+// mirror 在重复导出时按文件 ID 跟踪本地文件，并发安全
+type mirror struct {
+	mu           sync.Mutex
+	root         string
+	orphanAction string
+	// allowMassDelete 为 true 时不限制一个 scope 中云端已删除的文件数
+	allowMassDelete bool
+	// outputs 返回后处理生成的文件，postProcess 为移动后的文件重新执行后处理
+	outputs     func(p string) []string
+	postProcess func(p string)
+
+	old     map[string]mirrorEntry
+	next    map[string]mirrorEntry
+	pending map[string]mirrorEntry
+	seen    map[string]bool
+	// kept 为处理失败的文件，保留其上次的导出结果
+	kept map[string]bool
+	// scanned 为完整遍历的 scope，incomplete 为遍历中有目录失败的 scope
+	scanned    map[string]bool
+	incomplete map[string]bool
+	folders    map[string]bool
+	summary    MirrorSummary
+}
+
+func loadMirror(root, orphanAction string, allowMassDelete bool, outputs func(p string) []string, postProcess func(p string)) (*mirror, error) {
+	if orphanAction == "" {
+		orphanAction = OrphansMove
+	}
+	if orphanAction != OrphansMove && orphanAction != OrphansDelete {
+		return nil, fmt.Errorf("不支持的已删除文件处理方式 %s，可选 move、delete", orphanAction)
+	}
+
+	m := &mirror{
+		root:            root,
+		orphanAction:    orphanAction,
+		allowMassDelete: allowMassDelete,
+		outputs:         outputs,
+		postProcess:     postProcess,
+		old:             map[string]mirrorEntry{},
+		next:            map[string]mirrorEntry{},
+		pending:         map[string]mirrorEntry{},
+		seen:            map[string]bool{},
+		kept:            map[string]bool{},
+		scanned:         map[string]bool{},
+		incomplete:      map[string]bool{},
+		folders:         map[string]bool{},
+		summary:         MirrorSummary{OrphanAction: orphanAction},
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, StateFileName))
+	if errors.Is(err, fs.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("读取镜像状态失败: %w", err)
+	}
+	var st mirrorState
+	if err := json.Unmarshal(data, &st); err != nil {
+		return nil, fmt.Errorf("解析镜像状态失败 %s: %w", StateFileName, err)
+	}
+	for _, entry := range st.Entries {
+		m.old[entry.key()] = entry
+	}
+	return m, nil
+}
+
+func (m *mirror) local(name string) string {
+	return filepath.Join(m.root, filepath.FromSlash(name))
+}
+
+func (m *mirror) exists(name string) bool {
+	_, err := os.Stat(m.local(name))
+	return err == nil
+}
+
+// reuse 复用上次导出的结果：云端重命名或移动的文件先在本地移动到新路径，
+// 修改时间未变化时返回 true，无需再次下载
+func (m *mirror) reuse(entry mirrorEntry) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	key := entry.key()
+	m.seen[key] = true
+	old, ok := m.old[key]
+	if ok && old.Path != entry.Path && m.exists(old.Path) && !m.exists(entry.Path) {
+		if err := m.move(old.Path, entry.Path); err != nil {
+			global.Log.Error("镜像移动文件失败 %s -> %s: %v", old.Path, entry.Path, err)
+		} else {
+			m.summary.Moved = append(m.summary.Moved, MirrorMove{From: old.Path, To: entry.Path})
+			old.Path = entry.Path
+			m.old[key] = old
+		}
+	}
+
+	if ok && old.Path == entry.Path && entry.MTime > 0 && old.MTime == entry.MTime && m.exists(entry.Path) {
+		m.next[key] = entry
+		m.summary.Unchanged++
+		return true
+	}
+	m.pending[entry.Path] = entry
+	return false
+}
+
+// unchanged 判断文件自上次导出后是否未修改，用于规划阶段估算下载量
+func (m *mirror) unchanged(entry mirrorEntry) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	old, ok := m.old[entry.key()]
+	return ok && entry.MTime > 0 && old.MTime == entry.MTime && m.exists(old.Path)
+}
+
+// commit 在文件下载成功后记录其导出结果
+func (m *mirror) commit(p string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if entry, ok := m.pending[p]; ok {
+		m.next[entry.key()] = entry
+		delete(m.pending, p)
+	}
+}
+
+// keep 保留处理失败的文件上次的导出结果
+func (m *mirror) keep(scope string, fileID int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.kept[mirrorEntry{Scope: scope, FileID: fileID}.file()] = true
+}
+
+// folder 记录云端存在的文件夹，清理时不删除
+func (m *mirror) folder(name string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.folders[name] = true
+}
+
+// markScanned 标记 scope 已完整遍历，其中未出现的文件视为云端已删除
+func (m *mirror) markScanned(scope string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.scanned[scope] = true
+}
+
+// markIncomplete 标记 scope 中有目录遍历失败，不清理其中的文件
+func (m *mirror) markIncomplete(scope string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.incomplete[scope] = true
+}
+
+// move 将文件从 from 移动到 to，元数据、评论随之移动，后处理生成的文件删除后重新生成
+func (m *mirror) move(from, to string) error {
+	src, dst := m.local(from), m.local(to)
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	outputs := m.outputs(src)
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	for _, suffix := range sidecarSuffixes {
+		if _, err := os.Stat(src + suffix); err != nil {
+			continue
+		}
+		if err := os.Rename(src+suffix, dst+suffix); err != nil {
+			global.Log.Error("镜像移动旁路文件失败 %s: %v", src+suffix, err)
+		}
+	}
+	for _, p := range outputs {
+		if err := os.RemoveAll(p); err != nil {
+			global.Log.Error("删除后处理文件失败 %s: %v", p, err)
+		}
+	}
+	m.postProcess(dst)
+	m.removeEmptyDirs(filepath.Dir(src))
+	return nil
+}
+
+// sidecars 返回与 p 一起清理的旁路文件及后处理生成的文件，只包含已存在的文件
+func (m *mirror) sidecars(p string) []string {
+	var paths []string
+	for _, suffix := range sidecarSuffixes {
+		if _, err := os.Stat(p + suffix); err == nil {
+			paths = append(paths, p+suffix)
+		}
+	}
+	return append(paths, m.outputs(p)...)
+}
+
+// orphan 按配置将云端已删除的文件及其旁路文件移动到 .orphaned/ 或删除
+func (m *mirror) orphan(name string) error {
+	src := m.local(name)
+	paths := append([]string{src}, m.sidecars(src)...)
+	for _, p := range paths {
+		if m.orphanAction == OrphansDelete {
+			if err := os.RemoveAll(p); err != nil {
+				return err
+			}
+			continue
+		}
+
+		rel, err := filepath.Rel(m.root, p)
+		if err != nil {
+			return err
+		}
+		dst := filepath.Join(m.root, OrphanedDir, rel)
+		if _, err := os.Stat(dst); err == nil {
+			dst += "." + strconv.FormatInt(time.Now().Unix(), 10)
+		}
+		if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+			return err
+		}
+		if err := os.Rename(p, dst); err != nil {
+			return err
+		}
+	}
+	m.removeEmptyDirs(filepath.Dir(src))
+	return nil
+}
+
+// removeEmptyDirs 自下而上删除 dir 中云端已不存在的空目录
+func (m *mirror) removeEmptyDirs(dir string) {
+	for {
+		rel, err := filepath.Rel(m.root, dir)
+		if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+			return
+		}
+		name := filepath.ToSlash(rel)
+		// 一级目录为 group，保留
+		if m.folders[name] || !strings.Contains(name, "/") {
+			return
+		}
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
+
+// refused 返回云端消失的文件超过 mirrorMaxOrphanRatio 的 scope，按文件 ID 计数，调用时需持有锁
+func (m *mirror) refused(complete func(scope string) bool) map[string]bool {
+	refused := map[string]bool{}
+	if m.allowMassDelete {
+		return refused
+	}
+	total, present := map[string]map[string]bool{}, map[string]bool{}
+	for key, old := range m.old {
+		if !complete(old.Scope) {
+			continue
+		}
+		if total[old.Scope] == nil {
+			total[old.Scope] = map[string]bool{}
+		}
+		total[old.Scope][old.file()] = true
+		if _, ok := m.next[key]; ok || m.seen[key] || m.kept[old.file()] {
+			present[old.file()] = true
+		}
+	}
+	for scope, files := range total {
+		dropped := 0
+		for file := range files {
+			if !present[file] {
+				dropped++
+			}
+		}
+		if dropped >= mirrorMinOrphans && float64(dropped) > float64(len(files))*mirrorMaxOrphanRatio {
+			refused[scope] = true
+		}
+	}
+	return refused
+}
+
+// finish 清理云端已删除的文件，写入本次的镜像状态并返回改动汇总
+func (m *mirror) finish() (*MirrorSummary, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	complete := func(scope string) bool { return m.scanned[scope] && !m.incomplete[scope] }
+	refused := m.refused(complete)
+	for key, old := range m.old {
+		if _, ok := m.next[key]; ok {
+			continue
+		}
+		// 未完整遍历、下载失败或处理失败的文件及拒绝清理的 scope 保留上次的结果
+		if !complete(old.Scope) || refused[old.Scope] || m.seen[key] || m.kept[old.file()] {
+			m.next[key] = old
+		}
+	}
+
+	claimed := map[string]bool{}
+	for _, entry := range m.next {
+		claimed[entry.Path] = true
+	}
+	var orphans []string
+	for key, old := range m.old {
+		if entry, ok := m.next[key]; ok && entry.Path == old.Path {
+			continue
+		}
+		if claimed[old.Path] || !m.exists(old.Path) {
+			continue
+		}
+		orphans = append(orphans, old.Path)
+	}
+	sort.Strings(orphans)
+	for _, p := range orphans {
+		if err := m.orphan(p); err != nil {
+			global.Log.Error("处理云端已删除的文件失败 %s: %v", p, err)
+			continue
+		}
+		m.summary.Orphaned = append(m.summary.Orphaned, p)
+	}
+
+	for scope := range m.incomplete {
+		m.summary.Incomplete = append(m.summary.Incomplete, scope)
+	}
+	sort.Strings(m.summary.Incomplete)
+	for scope := range refused {
+		m.summary.Refused = append(m.summary.Refused, scope)
+	}
+	sort.Strings(m.summary.Refused)
+
+	st := mirrorState{UpdatedAt: time.Now()}
+	for _, entry := range m.next {
+		st.Entries = append(st.Entries, entry)
+	}
+	sort.Slice(st.Entries, func(i, j int) bool { return st.Entries[i].key() < st.Entries[j].key() })
+	data, err := json.MarshalIndent(st, "", "  ")
+	if err != nil {
+		return &m.summary, fmt.Errorf("序列化镜像状态失败: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(m.root, StateFileName), data, 0644); err != nil {
+		return &m.summary, fmt.Errorf("写入镜像状态失败: %w", err)
+	}
+	return &m.summary, nil
+}
+
+// mirrorScope 返回 st 对应的 scope
+func (e *Exporter) mirrorScope(st *state) string {
+	return e.sinkName(st.downloadDir)
+}
+
+// reuse 在镜像模式下复用上次的导出结果，返回 true 时文件无需下载
+func (c *HandleContext) reuse(entry ManifestEntry, target string) bool {
+	if c.e.mirror == nil {
+		return false
+	}
+	reused := c.e.mirror.reuse(mirrorEntry{
+		Scope:  c.e.mirrorScope(c.st),
+		FileID: c.File.ID,
+		Target: target,
+		Path:   entry.LocalPath,
+		MTime:  c.File.MTime,
+	})
+	if !reused {
+		return false
+	}
+	if c.e.dedupe != nil {
+		c.e.dedupe.seed(dedupeKey(c.File, target), entry.LocalPath)
+	}
+	c.e.record(entry, nil)
+	return true
+}
+
+// postProcessOutputs 返回后处理为 p 生成的文件，只包含已存在的文件
+func (e *Exporter) postProcessOutputs(p string) []string {
+	var paths []string
+	for _, pp := range e.postProcessors {
+		o, ok := pp.(outputLister)
+		if !ok || !pp.Match(p) {
+			continue
+		}
+		for _, out := range o.Outputs(p) {
+			if _, err := os.Stat(out); err == nil {
+				paths = append(paths, out)
+			}
+		}
+	}
+	return paths
+}
+
+// finishMirror 结束镜像同步并输出改动汇总
+func (e *Exporter) finishMirror() {
+	summary, err := e.mirror.finish()
+	if err != nil {
+		global.Log.Error(err.Error())
+	}
+	e.manifest.Mirror = summary
+
+	for _, mv := range summary.Moved {
+		display.Progress("🔀 %s -> %s", mv.From, mv.To)
+	}
+	action := "已删除"
+	if summary.OrphanAction == OrphansMove {
+		action = "已移动到 " + path.Join(e.downloadDir, OrphanedDir)
+	}
+	for _, p := range summary.Orphaned {
+		display.Progress("🗑️ %s %s", p, action)
+	}
+	for _, scope := range summary.Incomplete {
+		display.Progress("⚠️ %s 遍历不完整，未清理云端已删除的文件", scope)
+	}
+	for _, scope := range summary.Refused {
+		display.Progress("⚠️ %s 中超过 %.0f%% 的文件在云端消失，未清理，确认已删除时使用 --mirror-allow-mass-delete",
+			scope, mirrorMaxOrphanRatio*100)
+	}
+	display.Progress("🪞 镜像同步完成: 移动 %d 个，未变化 %d 个，云端已删除 %d 个",
+		len(summary.Moved), summary.Unchanged, len(summary.Orphaned))
+}
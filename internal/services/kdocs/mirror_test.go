@@ -0,0 +1,253 @@
+package kdocs
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+const testMTime = 100
+
+func testMirrorEntry(id int) mirrorEntry {
+	return mirrorEntry{Scope: "g", FileID: id, Target: "docx", Path: "g/f" + strconv.Itoa(id) + ".docx", MTime: testMTime}
+}
+
+// newTestMirror 在 root 中写入 n 个上次导出的文件 g/f<ID>.docx（ID 为 1..n）及其镜像状态，f1 带有元数据文件
+func newTestMirror(t *testing.T, root string, n int, orphans string, allowMassDelete bool) *mirror {
+	var st mirrorState
+	for id := 1; id <= n; id++ {
+		entry := testMirrorEntry(id)
+		writeTestFile(t, filepath.Join(root, entry.Path), "v1")
+		st.Entries = append(st.Entries, entry)
+	}
+	writeTestFile(t, filepath.Join(root, "g/f1.docx"+MetaSuffix), "{}")
+	data, err := json.Marshal(st)
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeTestFile(t, filepath.Join(root, StateFileName), string(data))
+
+	m, err := loadMirror(root, orphans, allowMassDelete, func(string) []string { return nil }, func(string) {})
+	if err != nil {
+		t.Fatalf("loadMirror: %v", err)
+	}
+	return m
+}
+
+func writeTestFile(t *testing.T, p, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// seeAll 模拟本次遍历中 ids 对应的文件未变化
+func seeAll(m *mirror, ids ...int) {
+	for _, id := range ids {
+		m.reuse(testMirrorEntry(id))
+	}
+}
+
+func TestMirrorFinish(t *testing.T) {
+	tests := []struct {
+		name    string
+		files   int
+		orphans string
+		allow   bool
+		run     func(t *testing.T, m *mirror)
+		// exist 及 missing 为相对于下载目录的路径
+		exist   []string
+		missing []string
+		// state 为写入镜像状态的文件 ID
+		state   []int
+		moved   int
+		orphan  int
+		refused bool
+	}{
+		{
+			name:  "云端重命名的文件及元数据在本地移动",
+			files: 1,
+			run: func(t *testing.T, m *mirror) {
+				entry := testMirrorEntry(1)
+				entry.Path = "g/sub/renamed.docx"
+				if !m.reuse(entry) {
+					t.Fatal("未修改的文件应复用")
+				}
+				m.markScanned("g")
+			},
+			exist:   []string{"g/sub/renamed.docx", "g/sub/renamed.docx" + MetaSuffix},
+			missing: []string{"g/f1.docx", "g/f1.docx" + MetaSuffix},
+			state:   []int{1},
+			moved:   1,
+		},
+		{
+			name:  "修改过的文件重新下载后记录",
+			files: 1,
+			run: func(t *testing.T, m *mirror) {
+				entry := testMirrorEntry(1)
+				entry.MTime = testMTime + 1
+				if m.reuse(entry) {
+					t.Fatal("修改过的文件不应复用")
+				}
+				m.commit(entry.Path)
+				m.markScanned("g")
+			},
+			exist: []string{"g/f1.docx"},
+			state: []int{1},
+		},
+		{
+			name:    "云端已删除的文件移动到 .orphaned",
+			files:   2,
+			orphans: OrphansMove,
+			run: func(t *testing.T, m *mirror) {
+				seeAll(m, 1)
+				m.markScanned("g")
+			},
+			exist:   []string{"g/f1.docx", OrphanedDir + "/g/f2.docx"},
+			missing: []string{"g/f2.docx"},
+			state:   []int{1},
+			orphan:  1,
+		},
+		{
+			name:    "云端已删除的文件及元数据直接删除",
+			files:   2,
+			orphans: OrphansDelete,
+			run: func(t *testing.T, m *mirror) {
+				seeAll(m, 2)
+				m.markScanned("g")
+			},
+			exist:   []string{"g/f2.docx"},
+			missing: []string{"g/f1.docx", "g/f1.docx" + MetaSuffix, OrphanedDir},
+			state:   []int{2},
+			orphan:  1,
+		},
+		{
+			name:    "遍历不完整时不清理",
+			files:   2,
+			orphans: OrphansDelete,
+			run: func(t *testing.T, m *mirror) {
+				seeAll(m, 1)
+				m.markScanned("g")
+				m.markIncomplete("g")
+			},
+			exist: []string{"g/f1.docx", "g/f2.docx"},
+			state: []int{1, 2},
+		},
+		{
+			name:    "只导出部分内容时不清理",
+			files:   2,
+			orphans: OrphansDelete,
+			run: func(t *testing.T, m *mirror) {
+				seeAll(m, 1)
+			},
+			exist: []string{"g/f1.docx", "g/f2.docx"},
+			state: []int{1, 2},
+		},
+		{
+			name:    "处理失败的文件保留上次的结果",
+			files:   2,
+			orphans: OrphansDelete,
+			run: func(t *testing.T, m *mirror) {
+				seeAll(m, 1)
+				m.keep("g", 2)
+				m.markScanned("g")
+			},
+			exist: []string{"g/f1.docx", "g/f2.docx"},
+			state: []int{1, 2},
+		},
+		{
+			name:    "大部分文件消失时拒绝清理",
+			files:   10,
+			orphans: OrphansDelete,
+			run: func(t *testing.T, m *mirror) {
+				seeAll(m, 1, 2, 3, 4)
+				m.markScanned("g")
+			},
+			exist:   []string{"g/f5.docx", "g/f10.docx"},
+			state:   []int{1, 2, 3, 4, 5, 6, 7, 8, 9, 10},
+			refused: true,
+		},
+		{
+			name:    "消失的文件少于下限时照常清理",
+			files:   6,
+			orphans: OrphansDelete,
+			run: func(t *testing.T, m *mirror) {
+				seeAll(m, 1, 2)
+				m.markScanned("g")
+			},
+			missing: []string{"g/f3.docx", "g/f6.docx"},
+			state:   []int{1, 2},
+			orphan:  4,
+		},
+		{
+			name:    "允许时清理大部分文件",
+			files:   10,
+			orphans: OrphansDelete,
+			allow:   true,
+			run: func(t *testing.T, m *mirror) {
+				seeAll(m, 1, 2, 3, 4)
+				m.markScanned("g")
+			},
+			exist:   []string{"g/f4.docx"},
+			missing: []string{"g/f5.docx", "g/f10.docx"},
+			state:   []int{1, 2, 3, 4},
+			orphan:  6,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			root := t.TempDir()
+			m := newTestMirror(t, root, tt.files, tt.orphans, tt.allow)
+			tt.run(t, m)
+			summary, err := m.finish()
+			if err != nil {
+				t.Fatalf("finish: %v", err)
+			}
+
+			for _, p := range tt.exist {
+				if _, err := os.Stat(filepath.Join(root, p)); err != nil {
+					t.Errorf("%s 应存在: %v", p, err)
+				}
+			}
+			for _, p := range tt.missing {
+				if _, err := os.Stat(filepath.Join(root, p)); err == nil {
+					t.Errorf("%s 不应存在", p)
+				}
+			}
+			if len(summary.Moved) != tt.moved {
+				t.Errorf("移动 %d 个文件，应为 %d 个", len(summary.Moved), tt.moved)
+			}
+			if len(summary.Orphaned) != tt.orphan {
+				t.Errorf("清理 %d 个文件，应为 %d 个: %v", len(summary.Orphaned), tt.orphan, summary.Orphaned)
+			}
+			if refused := len(summary.Refused) > 0; refused != tt.refused {
+				t.Errorf("拒绝清理为 %v，应为 %v", refused, tt.refused)
+			}
+
+			data, err := os.ReadFile(filepath.Join(root, StateFileName))
+			if err != nil {
+				t.Fatal(err)
+			}
+			var st mirrorState
+			if err := json.Unmarshal(data, &st); err != nil {
+				t.Fatal(err)
+			}
+			var ids []int
+			for _, entry := range st.Entries {
+				ids = append(ids, entry.FileID)
+			}
+			sort.Ints(ids)
+			if !slices.Equal(ids, tt.state) {
+				t.Errorf("镜像状态中的文件为 %v，应为 %v", ids, tt.state)
+			}
+		})
+	}
+}
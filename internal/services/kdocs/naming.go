@@ -0,0 +1,311 @@
+package kdocs
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"KingExporter/internal/services/api"
+	"github.com/samber/lo"
+	"golang.org/x/text/unicode/norm"
+)
+
+// 文件名清理规则
+const (
+	// NameRulesWindows 同时满足 Windows、macOS 及 Linux 的限制，是默认规则
+	NameRulesWindows = "windows"
+	// NameRulesPOSIX 只替换 / 及空字符，适合只在类 Unix 系统上使用的导出
+	NameRulesPOSIX = "posix"
+)
+
+// DefaultNameReplacement 替换文件名中不允许的字符
+const DefaultNameReplacement = "_"
+
+// MinNameLength 是 MaxNameLength 的最小值，需容纳哈希后缀及扩展名
+const MinNameLength = 32
+
+// maxKeptExt 为缩短名称时保留的最长扩展名，更长的视为名称的一部分
+const maxKeptExt = 16
+
+// windowsUnsafeChars 为 Windows 文件名中不允许的字符，控制字符另行处理
+const windowsUnsafeChars = `\/:*?"<>|`
+
+// windowsReservedNames 为 Windows 的设备名，带任意扩展名时同样不能作为文件名
+var windowsReservedNames = []string{
+	"CON", "PRN", "AUX", "NUL",
+	"COM1", "COM2", "COM3", "COM4", "COM5", "COM6", "COM7", "COM8", "COM9",
+	"LPT1", "LPT2", "LPT3", "LPT4", "LPT5", "LPT6", "LPT7", "LPT8", "LPT9",
+}
+
+// NameRules 控制云文件名转换为本地文件名的规则
+type NameRules struct {
+	// Mode 为 NameRulesWindows 或 NameRulesPOSIX，为空时使用 NameRulesWindows
+	Mode string `json:"mode,omitempty"`
+	// Replacement 替换不允许的字符，为空时使用 _
+	Replacement string `json:"replacement,omitempty"`
+	// NFC 将名称规范化为 Unicode NFC，避免 macOS 上传的 NFD 名称与 NFC 名称在部分文件系统上重复
+	NFC bool `json:"nfc,omitempty"`
+	// MaxNameLength 大于 0 时，超过该字节数的名称截断并追加原名称的哈希，例如 很长的名称~1a2b3c4d.docx，
+	// 每次导出结果一致。以 UTF-8 字节计算，同时满足 ext4 等按字节及 NTFS 等按 UTF-16 计算的限制
+	MaxNameLength int `json:"max_name_length,omitempty"`
+}
+
+func (r NameRules) mode() string {
+	if r.Mode == "" {
+		return NameRulesWindows
+	}
+	return r.Mode
+}
+
+func (r NameRules) replacement() string {
+	if r.Replacement == "" {
+		return DefaultNameReplacement
+	}
+	return r.Replacement
+}
+
+// Validate 检查清理规则，替换字符本身必须是合法的文件名字符
+func (r NameRules) Validate() error {
+	if r.mode() != NameRulesWindows && r.mode() != NameRulesPOSIX {
+		return fmt.Errorf("不支持的文件名规则 %s，可选 windows、posix", r.Mode)
+	}
+	rep := r.replacement()
+	if strings.ContainsFunc(rep, func(c rune) bool { return r.unsafe(c) }) || strings.Trim(rep, ".") == "" {
+		return fmt.Errorf("文件名替换字符 %q 不合法", rep)
+	}
+	if r.MaxNameLength != 0 && r.MaxNameLength < MinNameLength {
+		return fmt.Errorf("文件名长度上限不能小于 %d 字节", MinNameLength)
+	}
+	return nil
+}
+
+func (r NameRules) unsafe(c rune) bool {
+	if c == 0 || c == '/' {
+		return true
+	}
+	return r.mode() == NameRulesWindows && (c < 0x20 || c == 0x7f || strings.ContainsRune(windowsUnsafeChars, c))
+}
+
+// Clean 将云文件名转换为单个合法的本地路径段。结果不包含路径分隔符，也不会是 . 或 ..，
+// 因此远程名称无法写到所在目录之外
+func (r NameRules) Clean(name string) string {
+	return r.shorten(r.clean(name), false)
+}
+
+// clean 规范化名称并替换不允许的字符，不限制长度
+func (r NameRules) clean(name string) string {
+	if r.NFC {
+		name = norm.NFC.String(name)
+	}
+	rep := r.replacement()
+	var b strings.Builder
+	for _, c := range name {
+		if r.unsafe(c) {
+			b.WriteString(rep)
+		} else {
+			b.WriteRune(c)
+		}
+	}
+	name = b.String()
+
+	if r.mode() == NameRulesWindows {
+		// Windows 不允许文件名以空格或点结尾
+		name = strings.TrimRight(name, " .")
+		// 设备名追加替换字符，例如 CON.txt -> CON_.txt
+		stem, _, _ := strings.Cut(name, ".")
+		if lo.ContainsBy(windowsReservedNames, func(n string) bool { return strings.EqualFold(strings.TrimRight(stem, " "), n) }) {
+			name = stem + rep + name[len(stem):]
+		}
+	}
+	if name == "" || name == "." || name == ".." {
+		name = rep + name
+	}
+	return name
+}
+
+// key 返回比较重名时使用的名称，Windows 规则下不区分大小写
+func (r NameRules) key(name string) string {
+	name = r.clean(name)
+	if r.mode() == NameRulesWindows {
+		return strings.ToLower(name)
+	}
+	return name
+}
+
+// localName 返回清理后的本地名称，suffix 不为空时文件追加在扩展名前，文件夹追加在末尾
+func (r NameRules) localName(name, suffix string, folder bool) string {
+	name = r.clean(name)
+	if suffix != "" {
+		ext := filepath.Ext(name)
+		if folder || ext == "" || ext == name {
+			name += suffix
+		} else {
+			name = strings.TrimSuffix(name, ext) + suffix + ext
+		}
+	}
+	return r.shorten(name, folder)
+}
+
+// shorten 将超过 MaxNameLength 字节的名称按字符截断，追加原名称 SHA-1 的前 8 位，文件保留扩展名
+func (r NameRules) shorten(name string, folder bool) string {
+	if r.MaxNameLength <= 0 || len(name) <= r.MaxNameLength {
+		return name
+	}
+
+	sum := sha1.Sum([]byte(name))
+	hash := "~" + hex.EncodeToString(sum[:4])
+	ext := filepath.Ext(name)
+	// 未经 Validate 的规则中 MaxNameLength 可能小于 MinNameLength，放不下扩展名时不保留
+	if folder || ext == name || len(ext) > maxKeptExt || r.MaxNameLength-len(hash)-len(ext) < 1 {
+		ext = ""
+	}
+	stem := strings.TrimSuffix(name, ext)
+
+	keep := max(r.MaxNameLength-len(hash)-len(ext), 0)
+	for keep > 0 && !utf8.RuneStart(stem[keep]) {
+		keep--
+	}
+	stem = stem[:keep]
+	if r.mode() == NameRulesWindows {
+		stem = strings.TrimRight(stem, " .")
+	}
+	return stem + hash + ext
+}
+
+// collisionSuffixes 找出同一目录下清理或转码后重名的文件，例如同名的文件与文件夹，或转码为 .docx 的
+// .otl 与同名 .docx。重名的文件按 ID 排序，ID 最小的保留原名，其余追加 " (<ID>)"，多次导出结果一致。
+// names 返回文件导出到本地的名称，为空表示不导出
+func collisionSuffixes(files []api.File, rules NameRules, names func(f api.File) []string) map[int]string {
+	owners := map[string][]int{}
+	for _, f := range files {
+		for _, name := range names(f) {
+			key := rules.key(name)
+			if !lo.Contains(owners[key], f.ID) {
+				owners[key] = append(owners[key], f.ID)
+			}
+		}
+	}
+
+	suffixes := map[int]string{}
+	for _, ids := range owners {
+		if len(ids) < 2 {
+			continue
+		}
+		sort.Ints(ids)
+		for _, id := range ids[1:] {
+			suffixes[id] = fmt.Sprintf(" (%d)", id)
+		}
+	}
+	return suffixes
+}
+
+// targetNames 返回 exportTargets 的本地名称，文件夹返回其名称
+func targetNames(f api.File, convert ConvertMap) []string {
+	if f.FType == "folder" {
+		return []string{f.FName}
+	}
+	return lo.Map(exportTargets(f, convert), func(t exportTarget, _ int) string { return t.Name })
+}
+
+// location 是文件夹的远程路径及本地路径。本地路径中的名称经过清理及去重，与远程名称可能不同
+type location struct {
+	// remote 为相对 group 根目录、以 / 分隔的远程路径
+	remote string
+	// local 为相对 st.downloadDir 的本地路径
+	local string
+	// sanitized、collision 表示本地路径中有名称被清理或追加了重名后缀
+	sanitized bool
+	collision bool
+	// suffixes 为文件夹中重名文件的后缀，键为文件 ID
+	suffixes map[int]string
+	// taken 为文件夹中导出结果的本地名称，键为 NameRules.key，历史版本等额外生成的文件不能与其重名
+	taken map[string]bool
+}
+
+// child 返回子文件夹的 location
+func (l location) child(f api.File, rules NameRules) location {
+	suffix := l.suffixes[f.ID]
+	// 远程路径原样拼接，不能用 path.Join，否则名称中的 .. 会被解析
+	remote := f.FName
+	if l.remote != "" {
+		remote = l.remote + "/" + f.FName
+	}
+	return location{
+		remote:    remote,
+		local:     filepath.Join(l.local, rules.localName(f.FName, suffix, true)),
+		sanitized: l.sanitized || rules.Clean(f.FName) != f.FName,
+		collision: l.collision || suffix != "",
+	}
+}
+
+// remoteLocation 返回远程路径 segments 对应的 location
+func remoteLocation(segments []string, rules NameRules) location {
+	var l location
+	for _, name := range segments {
+		l = l.child(api.File{FName: name}, rules)
+	}
+	return l
+}
+
+// resolveNames 返回文件夹中文件的重名后缀及导出结果占用的本地名称
+func (e *Exporter) resolveNames(dir location, files []api.File) location {
+	// 后处理共用的目录（如 assets）由 ID 为 0 的文件夹占用，同名的云文件追加后缀
+	files = append(slices.Clone(files), e.sharedOutputs(files)...)
+	dir.suffixes = collisionSuffixes(files, e.names, e.exportNames)
+	dir.taken = map[string]bool{}
+	for _, f := range files {
+		for _, name := range e.exportNames(f) {
+			dir.taken[e.names.key(e.names.localName(name, dir.suffixes[f.ID], f.FType == "folder"))] = true
+		}
+	}
+	return dir
+}
+
+// exportNames 返回云文件导出到本地的名称，包括后处理生成的文件，用于检测重名。
+// 内置转码规则之外的文件由自定义 Handler 处理时，按原文件名计算
+func (e *Exporter) exportNames(f api.File) []string {
+	names := targetNames(f, e.convert)
+	if len(names) == 0 && e.handlerFor(f) != nil {
+		names = []string{f.FName}
+	}
+	if f.FType == "folder" {
+		return names
+	}
+	for _, name := range slices.Clone(names) {
+		outputs, _ := e.outputNames(name)
+		names = append(names, outputs...)
+	}
+	return names
+}
+
+// sharedOutputs 返回 files 的后处理共用的目录，以 ID 为 0 的文件夹表示
+func (e *Exporter) sharedOutputs(files []api.File) []api.File {
+	var dirs []string
+	for _, f := range files {
+		if f.FType == "folder" {
+			continue
+		}
+		for _, name := range e.exportNames(f) {
+			_, shared := e.outputNames(name)
+			dirs = append(dirs, shared...)
+		}
+	}
+	return lo.Map(lo.Uniq(dirs), func(name string, _ int) api.File { return api.File{FName: name, FType: "folder"} })
+}
+
+// groupDir 返回 group 在下载目录中的目录
+func (e *Exporter) groupDir(name string) string {
+	return path.Join(e.downloadDir, e.names.localName(name, "", true))
+}
+
+// withinDir 判断 p 是否位于 dir 之中
+func withinDir(dir, p string) bool {
+	rel, err := filepath.Rel(dir, p)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
@@ -0,0 +1,240 @@
+package kdocs
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"KingExporter/internal/services/api"
+)
+
+func TestNameRulesClean(t *testing.T) {
+	posix := NameRules{Mode: NameRulesPOSIX}
+	tests := []struct {
+		rules NameRules
+		name  string
+		want  string
+	}{
+		{NameRules{}, "报告.docx", "报告.docx"},
+		{NameRules{}, "a:b*c?.docx", "a_b_c_.docx"},
+		{NameRules{}, "a\x01b", "a_b"},
+		{NameRules{}, "../x", ".._x"},
+		{NameRules{}, `..\x`, ".._x"},
+		{NameRules{}, "..", "_"},
+		{NameRules{}, ".", "_"},
+		{NameRules{}, "", "_"},
+		{NameRules{}, "名称. . ", "名称"},
+		{NameRules{}, "...", "_"},
+		{NameRules{}, "CON", "CON_"},
+		{NameRules{}, "con.txt", "con_.txt"},
+		{NameRules{}, "LPT1 .tar.gz", "LPT1 _.tar.gz"},
+		{NameRules{}, "CONSOLE.txt", "CONSOLE.txt"},
+		{NameRules{Replacement: "-"}, "a/b", "a-b"},
+		{posix, "a:b*c?.docx", "a:b*c?.docx"},
+		{posix, "../x", ".._x"},
+		{posix, "..", "_.."},
+		{posix, "CON", "CON"},
+		{posix, "名称. ", "名称. "},
+		{posix, "a\x00b", "a_b"},
+		{NameRules{NFC: true}, "é.docx", "é.docx"},
+		{NameRules{}, "é.docx", "é.docx"},
+	}
+	for _, tt := range tests {
+		if got := tt.rules.Clean(tt.name); got != tt.want {
+			t.Errorf("%s Clean(%q) = %q，应为 %q", tt.rules.mode(), tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNameRulesCleanStaysWithinDir(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "group")
+	names := []string{"..", ".", "../x", "../../etc/passwd", `..\..\x`, "/abs", "a/../../b", "", " ", "..."}
+	for _, rules := range []NameRules{{}, {Mode: NameRulesPOSIX}, {MaxNameLength: MinNameLength}} {
+		for _, name := range names {
+			clean := rules.Clean(name)
+			if strings.ContainsAny(clean, `/`) || (rules.mode() == NameRulesWindows && strings.Contains(clean, `\`)) {
+				t.Errorf("%s Clean(%q) = %q 包含路径分隔符", rules.mode(), name, clean)
+			}
+			if p := filepath.Join(dir, clean); !withinDir(dir, p) || p == dir {
+				t.Errorf("%s Clean(%q) = %q 超出目录", rules.mode(), name, clean)
+			}
+		}
+	}
+}
+
+func TestWithinDir(t *testing.T) {
+	dir := filepath.FromSlash("/data/export")
+	tests := []struct {
+		p    string
+		want bool
+	}{
+		{"/data/export/a.docx", true},
+		{"/data/export/sub/../a.docx", true},
+		{"/data/export/..a.docx", true},
+		{"/data/export", true},
+		{"/data/export/../other", false},
+		{"/data/export2/a.docx", false},
+		{"/data", false},
+	}
+	for _, tt := range tests {
+		if got := withinDir(dir, filepath.FromSlash(tt.p)); got != tt.want {
+			t.Errorf("withinDir(%q) = %v，应为 %v", tt.p, got, tt.want)
+		}
+	}
+}
+
+func TestCollisionSuffixes(t *testing.T) {
+	file := func(id int, name string) api.File { return api.File{ID: id, FName: name, FType: "file"} }
+	folder := func(id int, name string) api.File { return api.File{ID: id, FName: name, FType: "folder"} }
+	names := func(f api.File) []string { return targetNames(f, nil) }
+
+	tests := []struct {
+		name  string
+		rules NameRules
+		files []api.File
+		want  map[int]string
+	}{
+		{
+			name:  "不区分大小写，ID 最小的保留原名",
+			files: []api.File{file(9, "Report.docx"), file(3, "report.docx"), file(5, "REPORT.docx")},
+			want:  map[int]string{5: " (5)", 9: " (9)"},
+		},
+		{
+			name:  "posix 规则区分大小写",
+			rules: NameRules{Mode: NameRulesPOSIX},
+			files: []api.File{file(9, "Report.docx"), file(3, "report.docx")},
+			want:  map[int]string{},
+		},
+		{
+			name:  "转码后与同名文件重名",
+			files: []api.File{file(5, "报告.otl"), file(3, "报告.docx")},
+			want:  map[int]string{5: " (5)"},
+		},
+		{
+			name:  "清理后重名",
+			files: []api.File{file(1, "a:b.docx"), file(2, "a?b.docx"), file(3, "a_b.docx")},
+			want:  map[int]string{2: " (2)", 3: " (3)"},
+		},
+		{
+			name:  "文件与文件夹重名",
+			files: []api.File{folder(7, "资料.docx"), file(8, "资料.docx")},
+			want:  map[int]string{8: " (8)"},
+		},
+		{
+			name:  "NFD 与 NFC 名称规范化后重名",
+			rules: NameRules{NFC: true},
+			files: []api.File{file(2, "é.docx"), file(1, "é.docx")},
+			want:  map[int]string{2: " (2)"},
+		},
+		{
+			name:  "不规范化时 NFD 与 NFC 名称不重名",
+			files: []api.File{file(2, "é.docx"), file(1, "é.docx")},
+			want:  map[int]string{},
+		},
+		{
+			name:  "不导出的文件不参与检测",
+			files: []api.File{file(1, "a.zip"), file(2, "a.zip")},
+			want:  map[int]string{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := collisionSuffixes(tt.files, tt.rules, names)
+			if len(got) != len(tt.want) {
+				t.Fatalf("后缀为 %v，应为 %v", got, tt.want)
+			}
+			for id, suffix := range tt.want {
+				if got[id] != suffix {
+					t.Errorf("文件 %d 的后缀为 %q，应为 %q", id, got[id], suffix)
+				}
+			}
+		})
+	}
+}
+
+func TestNameRulesLocalName(t *testing.T) {
+	rules := NameRules{}
+	tests := []struct {
+		name, suffix string
+		folder       bool
+		want         string
+	}{
+		{"报告.docx", " (5)", false, "报告 (5).docx"},
+		{"资料", " (8)", false, "资料 (8)"},
+		{"v1.0", " (8)", true, "v1.0 (8)"},
+		{".hidden", " (2)", false, ".hidden (2)"},
+		{"a:b.docx", "", false, "a_b.docx"},
+	}
+	for _, tt := range tests {
+		if got := rules.localName(tt.name, tt.suffix, tt.folder); got != tt.want {
+			t.Errorf("localName(%q, %q) = %q，应为 %q", tt.name, tt.suffix, got, tt.want)
+		}
+	}
+}
+
+func TestNameRulesShorten(t *testing.T) {
+	long := strings.Repeat("很长的名称", 20) + ".docx"
+	rules := NameRules{MaxNameLength: 40}
+
+	got := rules.Clean(long)
+	if len(got) > 40 {
+		t.Errorf("缩短后为 %d 字节，超过 40", len(got))
+	}
+	if !utf8.ValidString(got) {
+		t.Errorf("缩短后 %q 不是合法的 UTF-8", got)
+	}
+	if !strings.HasSuffix(got, ".docx") || !strings.Contains(got, "~") {
+		t.Errorf("缩短后 %q 应保留扩展名并追加哈希", got)
+	}
+	if again := rules.Clean(long); again != got {
+		t.Errorf("多次缩短结果不一致: %q、%q", got, again)
+	}
+	if other := rules.Clean(strings.Repeat("很长的名称", 21) + ".docx"); other == got {
+		t.Errorf("前缀相同的不同名称缩短后重名: %q", got)
+	}
+	if short := rules.Clean("短名称.docx"); short != "短名称.docx" {
+		t.Errorf("未超长的名称不应缩短: %q", short)
+	}
+	if folder := rules.localName(strings.Repeat("a", 50)+".docx", "", true); len(folder) > 40 || strings.HasSuffix(folder, ".docx") {
+		t.Errorf("文件夹缩短后 %q 不应保留扩展名", folder)
+	}
+
+	// 未经 Validate 的规则不能 panic
+	for _, max := range []int{1, 5, 9, 12, MinNameLength - 1} {
+		rules := NameRules{MaxNameLength: max}
+		if got := rules.Clean(long); got == "" || !utf8.ValidString(got) {
+			t.Errorf("MaxNameLength %d 时缩短为 %q", max, got)
+		}
+	}
+}
+
+func TestResolveNamesReservesPostProcessOutputs(t *testing.T) {
+	file := func(id int, name string) api.File { return api.File{ID: id, FName: name, FType: "file"} }
+	folder := func(id int, name string) api.File { return api.File{ID: id, FName: name, FType: "folder"} }
+	e := &Exporter{postProcessors: []PostProcessor{MarkdownProcessor{}}}
+
+	dir := e.resolveNames(location{}, []api.File{
+		file(5, "报告.docx"), folder(3, "报告.md"), file(8, "说明.docx"), folder(2, AssetsDir), file(9, "b.pdf"),
+	})
+	want := map[int]string{5: " (5)", 2: " (2)"}
+	if len(dir.suffixes) != len(want) {
+		t.Fatalf("后缀为 %v，应为 %v", dir.suffixes, want)
+	}
+	for id, suffix := range want {
+		if dir.suffixes[id] != suffix {
+			t.Errorf("文件 %d 的后缀为 %q，应为 %q", id, dir.suffixes[id], suffix)
+		}
+	}
+	for _, name := range []string{"报告 (5).md", "说明.md", AssetsDir, "assets (2)"} {
+		if !dir.taken[e.names.key(name)] {
+			t.Errorf("%s 应被占用", name)
+		}
+	}
+
+	// 没有需要后处理的文件时不占用 assets
+	dir = e.resolveNames(location{}, []api.File{folder(2, AssetsDir), file(9, "b.pdf")})
+	if len(dir.suffixes) != 0 {
+		t.Errorf("后缀为 %v，应为空", dir.suffixes)
+	}
+}
@@ -0,0 +1,75 @@
+package kdocs
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+
+	"KingExporter/internal/global"
+	"KingExporter/internal/services/api"
+)
+
+const PermissionsFileName = "permissions.json"
+
+// groupPermissions 是写入每个 group 目录下 permissions.json 的内容，用于在新系统中重建权限
+type groupPermissions struct {
+	GroupID   int               `json:"group_id"`
+	GroupName string            `json:"group_name"`
+	Members   []api.GroupMember `json:"members"`
+	Files     []filePermissions `json:"files"`
+}
+
+// filePermissions 记录单个文件或文件夹的分享链接及协作者，没有单独授权的文件不记录
+type filePermissions struct {
+	FileID        int                `json:"file_id"`
+	Type          string             `json:"type"`
+	RemotePath    string             `json:"remote_path"`
+	Links         []api.ShareLink    `json:"links,omitempty"`
+	Collaborators []api.Collaborator `json:"collaborators,omitempty"`
+}
+
+func (e *Exporter) newGroupPermissions(groupID int, name string) *groupPermissions {
+	members, err := e.api.GroupMembers(groupID)
+	if err != nil {
+		global.Log.Error("获取 group 成员失败 groupID %d: %v", groupID, err)
+	}
+	return &groupPermissions{GroupID: groupID, GroupName: name, Members: members}
+}
+
+// collectPermissions 获取文件或文件夹的分享链接及协作者
+func (e *Exporter) collectPermissions(f api.File, groupID int, remoteDir string, st *state) {
+	if st.permissions == nil {
+		return
+	}
+
+	links, err := e.api.ShareLinks(f.ID)
+	if err != nil {
+		global.Log.Error(e.logError("获取分享链接失败", err, f, groupID))
+	}
+	collaborators, err := e.api.Collaborators(groupID, f.ID)
+	if err != nil {
+		global.Log.Error(e.logError("获取协作者失败", err, f, groupID))
+	}
+	if len(links) == 0 && len(collaborators) == 0 {
+		return
+	}
+
+	st.permissions.Files = append(st.permissions.Files, filePermissions{
+		FileID:        f.ID,
+		Type:          f.FType,
+		RemotePath:    joinRemotePath("/"+remoteDir, f.FName),
+		Links:         links,
+		Collaborators: collaborators,
+	})
+}
+
+func (e *Exporter) savePermissions(p *groupPermissions, dir string) error {
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化权限信息失败: %w", err)
+	}
+	if err := e.writeFile(filepath.Join(dir, PermissionsFileName), data); err != nil {
+		return fmt.Errorf("写入权限信息失败: %w", err)
+	}
+	return nil
+}
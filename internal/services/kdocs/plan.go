@@ -0,0 +1,547 @@
+package kdocs
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"KingExporter/internal/global"
+	"KingExporter/internal/services/api"
+	"KingExporter/pkg/display"
+	"github.com/samber/lo"
+)
+
+// PlanVersion 为 plan.json 的格式版本
+const PlanVersion = 1
+
+// 计划中导出结果的生成方式
+const (
+	ActionDownload = "download"
+	ActionConvert  = "convert"
+)
+
+// 计划中无需下载的导出结果
+const (
+	// NoteUnchanged 为镜像模式下未变化的文件
+	NoteUnchanged = "unchanged"
+	// NoteDuplicate 为去重模式下与其他文件内容相同的文件
+	NoteDuplicate = "duplicate"
+)
+
+// PlanOptions 为决定导出范围及本地路径的参数，apply 时取自计划
+type PlanOptions struct {
+	All            bool       `json:"all,omitempty"`
+	Path           string     `json:"path,omitempty"`
+	FolderID       int        `json:"folder_id,omitempty"`
+	FileID         int        `json:"file_id,omitempty"`
+	IncludeShared  bool       `json:"include_shared,omitempty"`
+	IncludeStarred bool       `json:"include_starred,omitempty"`
+	IncludeRecent  bool       `json:"include_recent,omitempty"`
+	IncludeTrash   bool       `json:"include_trash,omitempty"`
+	Convert        ConvertMap `json:"convert,omitempty"`
+	Names          NameRules  `json:"names"`
+}
+
+// PlanAction 是计划中的一个导出结果
+type PlanAction struct {
+	// Scope 为所在 group 或虚拟 group 的目录
+	Scope   string `json:"scope"`
+	GroupID int    `json:"group_id"`
+	FileID  int    `json:"file_id"`
+	// Target 为直接下载时的扩展名或转码的目标格式
+	Target string `json:"target"`
+	// Action 为 download 或 convert
+	Action     string `json:"action"`
+	RemotePath string `json:"remote_path"`
+	// LocalPath 相对于下载目录的路径
+	LocalPath string `json:"local_path"`
+	Size      int    `json:"size"`
+	MTime     int64  `json:"mtime"`
+	// Note 为 unchanged 或 duplicate 时无需下载
+	Note string `json:"note,omitempty"`
+}
+
+func actionKey(scope string, fileID int, target string) string {
+	return scope + "/" + strconv.Itoa(fileID) + "/" + target
+}
+
+// PlanSummary 汇总计划需要下载的文件
+type PlanSummary struct {
+	// Files、Bytes 为需要下载的文件数及按云文件大小估算的下载量，转码结果的大小可能不同
+	Files int   `json:"files"`
+	Bytes int64 `json:"bytes"`
+	// Unchanged 为镜像模式下未变化的文件数，Duplicates 为去重模式下重复的文件数，均无需下载
+	Unchanged  int `json:"unchanged,omitempty"`
+	Duplicates int `json:"duplicates,omitempty"`
+	// Incomplete 表示有列表获取失败，计划中缺少其中的文件
+	Incomplete bool `json:"incomplete,omitempty"`
+}
+
+// Plan 是导出前遍历得到的远程目录快照及计划的导出结果。
+//
+// KingExporter plan 将其保存为 plan.json，审阅或删除 Actions 中的条目后由 KingExporter apply 执行。
+// 执行时只使用 Listings 中的列表，不再遍历远程目录，已删除的条目不导出；其余字段仅供审阅，修改后不生效。
+// 历史版本的大小需要逐个文件查询，不计入计划
+type Plan struct {
+	Version   int         `json:"version"`
+	CreatedAt time.Time   `json:"created_at"`
+	Options   PlanOptions `json:"options"`
+	// Groups 为导出的 group，Target 为 --folder_id 或 --file_id 指定的文件夹或文件
+	Groups  []api.Group  `json:"groups"`
+	Target  *api.File    `json:"target,omitempty"`
+	Summary PlanSummary  `json:"summary"`
+	Actions []PlanAction `json:"actions"`
+	// Listings 为遍历时获取的目录、回收站及虚拟 group 列表，键为列表类型及 ID
+	Listings map[string]any `json:"listings"`
+
+	// keys 为去重模式下已计入的内容
+	keys map[string]bool
+}
+
+// LoadPlan 读取 KingExporter plan 保存的计划
+func LoadPlan(name string) (*Plan, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("读取导出计划失败: %w", err)
+	}
+	// Listings 按键的类型分别解析
+	var raw struct {
+		Plan
+		Listings map[string]json.RawMessage `json:"listings"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return nil, fmt.Errorf("解析导出计划失败 %s: %w", name, err)
+	}
+	if raw.Version != PlanVersion {
+		return nil, fmt.Errorf("不支持的导出计划版本 %d", raw.Version)
+	}
+
+	p := raw.Plan
+	p.Listings = map[string]any{}
+	for key, v := range raw.Listings {
+		if p.Listings[key], err = decodeListing(key, v); err != nil {
+			return nil, fmt.Errorf("解析导出计划中的列表 %s 失败: %w", key, err)
+		}
+	}
+	return &p, nil
+}
+
+func decodeListing(key string, data json.RawMessage) (any, error) {
+	kind, _, _ := strings.Cut(key, "/")
+	switch kind {
+	case "files":
+		return decodeAs[[]api.File](data)
+	case "trash":
+		return decodeAs[[]api.TrashFile](data)
+	case "virtual":
+		return decodeAs[[]api.LinkedFile](data)
+	case "root":
+		return decodeAs[int](data)
+	case "parent":
+		return decodeAs[string](data)
+	}
+	return nil, fmt.Errorf("未知的列表类型 %s", kind)
+}
+
+func decodeAs[T any](data json.RawMessage) (any, error) {
+	var v T
+	err := json.Unmarshal(data, &v)
+	return v, err
+}
+
+// Save 将计划写入 name，条目按本地路径排序，便于比较不同时间的计划
+func (p *Plan) Save(name string) error {
+	sort.SliceStable(p.Actions, func(i, j int) bool { return p.Actions[i].LocalPath < p.Actions[j].LocalPath })
+	data, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return fmt.Errorf("序列化导出计划失败: %w", err)
+	}
+	if err := os.WriteFile(name, data, 0644); err != nil {
+		return fmt.Errorf("写入导出计划失败: %w", err)
+	}
+	return nil
+}
+
+// PrintSummary 向 w 输出计划的下载量
+func (p *Plan) PrintSummary(w io.Writer) {
+	s := p.Summary
+	fmt.Fprintf(w, "📋 计划下载 %d 个文件，约 %s", s.Files, display.FormatBytes(s.Bytes))
+	if s.Unchanged > 0 || s.Duplicates > 0 {
+		fmt.Fprintf(w, "（未变化 %d 个，重复 %d 个）", s.Unchanged, s.Duplicates)
+	}
+	fmt.Fprintln(w)
+	if s.Incomplete {
+		fmt.Fprintln(w, "⚠️ 部分列表获取失败，计划中缺少其中的文件")
+	}
+}
+
+// allowed 返回计划中的导出结果，目标为空的键表示文件有任意导出结果
+func (p *Plan) allowed() map[string]bool {
+	keys := map[string]bool{}
+	for _, a := range p.Actions {
+		keys[actionKey(a.Scope, a.FileID, a.Target)] = true
+		keys[actionKey(a.Scope, a.FileID, "")] = true
+	}
+	return keys
+}
+
+// listings 缓存规划阶段获取的列表，导出时取出使用，每个列表只请求一次，并发安全
+type listings struct {
+	mu    sync.Mutex
+	items map[string]any
+	// pinned 为 true 时列表来自导出计划，取出后保留，没有的列表不再请求
+	pinned bool
+}
+
+func newListings() *listings {
+	return &listings{items: map[string]any{}}
+}
+
+func (l *listings) put(key string, v any) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.items[key] = v
+}
+
+// take 取出 key 的缓存，不是来自导出计划时释放
+func (l *listings) take(key string) (any, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	v, ok := l.items[key]
+	if !l.pinned {
+		delete(l.items, key)
+	}
+	return v, ok
+}
+
+// snapshot 返回当前缓存的全部列表
+func (l *listings) snapshot() map[string]any {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	items := make(map[string]any, len(l.items))
+	for k, v := range l.items {
+		items[k] = v
+	}
+	return items
+}
+
+// prefetch 获取 key 的列表并缓存，供导出阶段使用
+func prefetch[T any](e *Exporter, key string, fetch func() (T, error)) (T, error) {
+	if e.listings.pinned {
+		return listing(e, key, fetch)
+	}
+	v, err := fetch()
+	if err == nil {
+		e.listings.put(key, v)
+	}
+	return v, err
+}
+
+// listing 优先使用缓存的列表，没有时直接获取。从计划导出时只使用计划中的列表
+func listing[T any](e *Exporter, key string, fetch func() (T, error)) (T, error) {
+	if v, ok := e.listings.take(key); ok {
+		return v.(T), nil
+	}
+	if e.listings.pinned {
+		var zero T
+		return zero, fmt.Errorf("导出计划中没有 %s 的列表", key)
+	}
+	return fetch()
+}
+
+func filesKey(groupID, folderID int) string {
+	return fmt.Sprintf("files/%d/%d", groupID, folderID)
+}
+
+func trashKey(groupID int) string {
+	return fmt.Sprintf("trash/%d", groupID)
+}
+
+func virtualKey(name string) string {
+	return "virtual/" + name
+}
+
+// rootKey 为 --path 在 group 中对应的文件夹 ID
+func rootKey(groupID int) string {
+	return fmt.Sprintf("root/%d", groupID)
+}
+
+// parentKey 为文件所在文件夹的远程路径
+func parentKey(fileID int) string {
+	return fmt.Sprintf("parent/%d", fileID)
+}
+
+// files 返回 folderID 中的文件
+func (e *Exporter) files(groupID, folderID int) ([]api.File, error) {
+	return listing(e, filesKey(groupID, folderID), func() ([]api.File, error) { return e.api.Files(groupID, folderID) })
+}
+
+// rootFolder 返回 --path 在 group 中对应的文件夹 ID，未指定时为根目录
+func (e *Exporter) rootFolder(groupID int) (int, error) {
+	if e.remotePath == "" {
+		return 0, nil
+	}
+	return listing(e, rootKey(groupID), func() (int, error) { return resolveRemotePath(e.api, groupID, e.remotePath) })
+}
+
+// parentOf 返回文件所在文件夹相对 group 根目录的路径
+func (e *Exporter) parentOf(f api.File) (string, error) {
+	return listing(e, parentKey(f.ID), func() (string, error) { return parentPath(e.api, f) })
+}
+
+// planned 判断导出计划中是否包含该导出结果，target 为空时判断文件是否有任意导出结果。不是从计划导出时总是返回 true
+func (e *Exporter) planned(scope string, fileID int, target string) bool {
+	return e.allowed == nil || e.allowed[actionKey(scope, fileID, target)]
+}
+
+func (e *Exporter) planOptions() PlanOptions {
+	return PlanOptions{
+		All:            e.selector.All,
+		Path:           e.remotePath,
+		FolderID:       e.folderID,
+		FileID:         e.fileID,
+		IncludeShared:  e.includeShared,
+		IncludeStarred: e.includeStarred,
+		IncludeRecent:  e.includeRecent,
+		IncludeTrash:   e.includeTrash,
+		Convert:        e.convert,
+		Names:          e.names,
+	}
+}
+
+// Plan 遍历将要导出的文件并返回导出计划，不下载任何文件
+func (e *Exporter) Plan() *Plan {
+	p := e.plan(e.exportScope())
+	p.Listings = e.listings.snapshot()
+	return p
+}
+
+// plan 遍历 selected 中将要导出的文件，生成计划的导出结果，获取的列表在导出时复用。
+// target 不为空时只规划 --folder_id 或 --file_id 指定的文件夹或文件，groups 用于查找其所在 group 的名称
+func (e *Exporter) plan(groups, selected []api.Group, target *api.File) *Plan {
+	p := &Plan{
+		Version:   PlanVersion,
+		CreatedAt: time.Now(),
+		Options:   e.planOptions(),
+		Groups:    selected,
+		Target:    target,
+		keys:      map[string]bool{},
+	}
+	if target != nil {
+		p.Groups = lo.Filter(groups, func(g api.Group, _ int) bool { return g.ID == target.GroupID })
+		scope := e.sinkName(e.groupDir(groupName(groups, target.GroupID)))
+		parent, err := prefetch(e, parentKey(target.ID), func() (string, error) { return parentPath(e.api, *target) })
+		if err != nil {
+			global.Log.Warn("规划导出 %s 失败: %v", target.FName, err)
+			p.Summary.Incomplete = true
+			return p
+		}
+		dir := remoteLocation(splitRemotePath(parent), e.names)
+		if target.FType == "folder" {
+			e.planFolder(p, target.GroupID, target.ID, scope, dir.child(*target, e.names))
+		} else {
+			e.planFile(p, *target, target.GroupID, scope, dir)
+		}
+		return p
+	}
+
+	for _, g := range selected {
+		scope := e.sinkName(e.groupDir(g.Name))
+		folderID, dir := 0, location{}
+		if e.remotePath != "" {
+			id, err := prefetch(e, rootKey(g.ID), func() (int, error) { return resolveRemotePath(e.api, g.ID, e.remotePath) })
+			if err != nil {
+				global.Log.Warn("规划导出 group %d 失败: %v", g.ID, err)
+				p.Summary.Incomplete = true
+				continue
+			}
+			folderID, dir = id, remoteLocation(splitRemotePath(e.remotePath), e.names)
+		}
+		e.planFolder(p, g.ID, folderID, scope, dir)
+		if e.includeTrash {
+			e.planTrash(p, g.ID, scope)
+		}
+	}
+
+	for _, v := range e.virtualGroups() {
+		items, err := prefetch(e, virtualKey(v.name), v.list)
+		if err != nil {
+			global.Log.Warn("规划导出时获取 %s 列表失败: %v", v.name, err)
+			p.Summary.Incomplete = true
+			continue
+		}
+		scope := e.sinkName(e.groupDir(v.name))
+		dirs := e.virtualDirs(v, items)
+		for _, item := range items {
+			dir := dirs[v.owner(item)]
+			if item.FType == "folder" {
+				e.planFolder(p, item.GroupID, item.ID, scope, dir.child(item.File, e.names))
+			} else {
+				e.planFile(p, item.File, item.GroupID, scope, dir)
+			}
+		}
+	}
+	return p
+}
+
+func (e *Exporter) planFolder(p *Plan, groupID, folderID int, scope string, dir location) {
+	files, err := prefetch(e, filesKey(groupID, folderID), func() ([]api.File, error) { return e.api.Files(groupID, folderID) })
+	if err != nil {
+		global.Log.Warn("规划导出时获取目录文件失败 folderID %d: %v", folderID, err)
+		p.Summary.Incomplete = true
+		return
+	}
+	dir = e.resolveNames(dir, files)
+	for _, f := range files {
+		if f.FType == "folder" {
+			e.planFolder(p, groupID, f.ID, scope, dir.child(f, e.names))
+		} else {
+			e.planFile(p, f, groupID, scope, dir)
+		}
+	}
+}
+
+// planFile 加入文件的导出结果，镜像模式下未变化及去重模式下重复的结果不计入下载量
+func (e *Exporter) planFile(p *Plan, f api.File, groupID int, scope string, dir location) {
+	for _, t := range e.planTargets(f) {
+		target := t.target()
+		if !e.planned(scope, f.ID, target) {
+			continue
+		}
+		a := e.planAction(f, groupID, scope, dir, t, dir.suffixes[f.ID])
+		switch {
+		case e.mirror != nil && e.mirror.unchanged(mirrorEntry{Scope: scope, FileID: f.ID, Target: target, MTime: f.MTime}):
+			a.Note = NoteUnchanged
+			p.Summary.Unchanged++
+		case e.dedupe != nil && p.keys[dedupeKey(f, target)]:
+			a.Note = NoteDuplicate
+			p.Summary.Duplicates++
+		default:
+			if e.dedupe != nil {
+				p.keys[dedupeKey(f, target)] = true
+			}
+			p.Summary.Files++
+			p.Summary.Bytes += int64(f.FSize)
+		}
+		p.Actions = append(p.Actions, a)
+	}
+}
+
+// planTrash 加入回收站中的文件，回收站中的文件不参与镜像及去重，每次都下载
+func (e *Exporter) planTrash(p *Plan, groupID int, scope string) {
+	files, err := prefetch(e, trashKey(groupID), func() ([]api.TrashFile, error) { return e.api.Trash(groupID) })
+	if err != nil {
+		global.Log.Warn("规划导出时获取回收站文件失败 groupID %d: %v", groupID, err)
+		p.Summary.Incomplete = true
+		return
+	}
+	suffixes := e.trashSuffixes(files)
+	for _, f := range files {
+		if f.FType == "folder" {
+			continue
+		}
+		loc := trashLocation(f, e.names)
+		for _, t := range e.planTargets(f.File) {
+			if !e.planned(scope, f.ID, t.target()) {
+				continue
+			}
+			p.Actions = append(p.Actions, e.planAction(f.File, groupID, scope, loc, t, suffixes[f.OriginalPath][f.ID]))
+			p.Summary.Files++
+			p.Summary.Bytes += int64(f.FSize)
+		}
+	}
+}
+
+func (e *Exporter) planAction(f api.File, groupID int, scope string, dir location, t exportTarget, suffix string) PlanAction {
+	return PlanAction{
+		Scope:      scope,
+		GroupID:    groupID,
+		FileID:     f.ID,
+		Target:     t.target(),
+		Action:     lo.Ternary(t.Format != "", ActionConvert, ActionDownload),
+		RemotePath: joinRemotePath("/"+dir.remote, f.FName),
+		LocalPath:  path.Join(scope, filepath.ToSlash(dir.local), e.names.localName(t.Name, suffix, false)),
+		Size:       f.FSize,
+		MTime:      f.MTime,
+	}
+}
+
+// planTargets 返回文件的导出结果，自定义 Handler 处理的文件按原文件下载计算
+func (e *Exporter) planTargets(f api.File) []exportTarget {
+	if e.handlerFor(f) == nil {
+		return nil
+	}
+	if targets := exportTargets(f, e.convert); len(targets) > 0 {
+		return targets
+	}
+	return []exportTarget{{Name: f.FName}}
+}
+
+// spaceDir 返回导出结果所在的本地目录，写入对象存储、WebDAV 等远程位置时返回空
+func (e *Exporter) spaceDir() string {
+	switch s := e.sink.(type) {
+	case *localSink:
+		return s.root
+	case *archive:
+		return filepath.Dir(e.outputArchive)
+	}
+	return ""
+}
+
+// preflight 输出规划结果，剩余空间不足时拒绝导出，静默模式下只警告。
+// 规划结果与导出进度一样输出到 stderr
+func (e *Exporter) preflight(p *Plan) {
+	p.PrintSummary(os.Stderr)
+	if e.allVersions {
+		display.Progress("⚠️ 历史版本的大小未计入")
+	}
+
+	need := p.Summary.Bytes
+	if e.budget != nil && need > e.budget.limit {
+		display.Progress("⚠️ 计划下载量超过下载上限 %s，达到上限后停止下载", display.FormatBytes(e.budget.limit))
+		need = e.budget.limit
+	}
+
+	dir := e.spaceDir()
+	if dir == "" {
+		return
+	}
+	free, err := freeSpace(dir)
+	if err != nil {
+		global.Log.Warn("获取剩余空间失败 %s: %v", dir, err)
+		return
+	}
+	if uint64(need) <= free {
+		return
+	}
+
+	err = fmt.Errorf("%s 剩余空间 %s，不足以导出约 %s 的文件", dir, display.FormatBytes(int64(free)), display.FormatBytes(need))
+	if e.silent {
+		global.Log.Warn(err.Error())
+		display.Progress("⚠️ %s", err)
+		return
+	}
+	global.Log.Error(err.Error())
+	e.discard()
+	display.Exit(1, "%s，请清理空间或通过 --max-total-size 限制下载量", err)
+}
+
+// discard 在开始导出前放弃导出，删除已创建的归档文件
+func (e *Exporter) discard() {
+	if err := e.sink.Close(); err != nil {
+		global.Log.Error(err.Error())
+	}
+	if _, ok := e.sink.(*archive); ok {
+		if err := os.Remove(e.outputArchive); err != nil {
+			global.Log.Error("删除归档文件失败 %s: %v", e.outputArchive, err)
+		}
+	}
+}
@@ -0,0 +1,172 @@
+package kdocs
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"KingExporter/internal/services/api"
+)
+
+func testPlan() *Plan {
+	return &Plan{
+		Version:   PlanVersion,
+		CreatedAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+		Options: PlanOptions{
+			All:          true,
+			Path:         "/项目",
+			IncludeTrash: true,
+			Convert:      ConvertMap{".otl": {"docx", "pdf"}},
+			Names:        NameRules{Mode: NameRulesPOSIX, MaxNameLength: 64},
+		},
+		Groups: []api.Group{{ID: 1, Name: "团队"}},
+		Actions: []PlanAction{
+			{Scope: "团队", GroupID: 1, FileID: 10, Target: "docx", Action: ActionConvert, LocalPath: "团队/项目/a.docx"},
+			{Scope: "团队", GroupID: 1, FileID: 10, Target: "pdf", Action: ActionConvert, LocalPath: "团队/项目/a.pdf"},
+			{Scope: "团队", GroupID: 1, FileID: 11, Target: ".xlsx", Action: ActionDownload, LocalPath: "团队/项目/b.xlsx"},
+		},
+		Listings: map[string]any{
+			filesKey(1, 42): []api.File{
+				{ID: 10, GroupID: 1, ParentID: 42, FName: "a.otl", FType: "file", MTime: 1700000000},
+				{ID: 11, GroupID: 1, ParentID: 42, FName: "b.xlsx", FType: "file", FSize: 2048},
+				{ID: 12, GroupID: 1, ParentID: 42, FName: "c.docx", FType: "file"},
+			},
+			trashKey(1):        []api.TrashFile{{File: api.File{ID: 20, FName: "old.docx"}, OriginalPath: "/项目", DeletedTime: 1700000001}},
+			virtualKey("与我共享"): []api.LinkedFile{{File: api.File{ID: 30, GroupID: 2, FName: "shared.pdf"}, Owner: api.FileOwner{ID: 5, Name: "alice"}}},
+			rootKey(1):         42,
+			parentKey(10):      "/项目",
+		},
+	}
+}
+
+func TestPlanSaveLoad(t *testing.T) {
+	p := testPlan()
+	name := filepath.Join(t.TempDir(), "plan.json")
+	if err := p.Save(name); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	loaded, err := LoadPlan(name)
+	if err != nil {
+		t.Fatalf("LoadPlan: %v", err)
+	}
+
+	if !loaded.CreatedAt.Equal(p.CreatedAt) {
+		t.Errorf("CreatedAt 为 %v，应为 %v", loaded.CreatedAt, p.CreatedAt)
+	}
+	if !reflect.DeepEqual(loaded.Options, p.Options) {
+		t.Errorf("Options 为 %+v，应为 %+v", loaded.Options, p.Options)
+	}
+	if !reflect.DeepEqual(loaded.Actions, p.Actions) {
+		t.Errorf("Actions 为 %+v，应为 %+v", loaded.Actions, p.Actions)
+	}
+	// 列表按键的前缀解析为原来的类型
+	for key, want := range p.Listings {
+		got := loaded.Listings[key]
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("列表 %s 为 %#v，应为 %#v", key, got, want)
+		}
+	}
+	if len(loaded.Listings) != len(p.Listings) {
+		t.Errorf("列表数为 %d，应为 %d", len(loaded.Listings), len(p.Listings))
+	}
+}
+
+func TestLoadPlanErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		err  string
+	}{
+		{"版本不支持", `{"version": 2}`, "版本"},
+		{"未知的列表类型", `{"version": 1, "listings": {"unknown/1": []}}`, "unknown/1"},
+		{"列表类型不匹配", `{"version": 1, "listings": {"root/1": "x"}}`, "root/1"},
+		{"不是 JSON", `plan`, "解析导出计划失败"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "plan.json")
+			if err := os.WriteFile(name, []byte(tt.data), 0644); err != nil {
+				t.Fatal(err)
+			}
+			_, err := LoadPlan(name)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("错误为 %v，应包含 %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestExportFromPlan(t *testing.T) {
+	p := testPlan()
+	// 审阅时删除 a.otl 的 pdf 导出结果
+	p.Actions = append(p.Actions[:1], p.Actions[2:]...)
+	e := newExporter("", ExportOptions{Plan: p, Convert: ConvertMap{".otl": {"xlsx"}}, Names: NameRules{}})
+
+	if !e.selector.All || e.remotePath != "/项目" || !e.includeTrash {
+		t.Errorf("导出范围应取自计划: all %v path %q trash %v", e.selector.All, e.remotePath, e.includeTrash)
+	}
+	if !reflect.DeepEqual(e.convert, p.Options.Convert) || e.names != p.Options.Names {
+		t.Errorf("转码格式及文件名规则应取自计划: %v %+v", e.convert, e.names)
+	}
+
+	tests := []struct {
+		fileID int
+		target string
+		want   bool
+	}{
+		{10, "docx", true},
+		{10, "pdf", false},
+		{10, "", true},
+		{11, ".xlsx", true},
+		{12, ".docx", false},
+		{12, "", false},
+	}
+	for _, tt := range tests {
+		if got := e.planned("团队", tt.fileID, tt.target); got != tt.want {
+			t.Errorf("planned(%d, %q) = %v，应为 %v", tt.fileID, tt.target, got, tt.want)
+		}
+	}
+	if e.planned("其他", 10, "docx") {
+		t.Error("其他 scope 中的同一文件不在计划中")
+	}
+
+	// 只使用计划中的列表，多次读取结果相同，没有的列表不再请求
+	for i := 0; i < 2; i++ {
+		files, err := e.files(1, 42)
+		if err != nil || len(files) != 3 {
+			t.Fatalf("files(1, 42) = %v, %v", files, err)
+		}
+	}
+	if _, err := e.files(1, 43); err == nil {
+		t.Error("计划中没有的列表应返回错误")
+	}
+	if id, err := e.rootFolder(1); err != nil || id != 42 {
+		t.Errorf("rootFolder(1) = %d, %v，应为 42", id, err)
+	}
+
+	// 重新规划时删除的导出结果不计入
+	replanned := &Plan{keys: map[string]bool{}}
+	files, _ := e.files(1, 42)
+	dir := e.resolveNames(remoteLocation([]string{"项目"}, e.names), files)
+	for _, f := range files {
+		e.planFile(replanned, f, 1, "团队", dir)
+	}
+	var targets []string
+	for _, a := range replanned.Actions {
+		targets = append(targets, a.LocalPath)
+	}
+	want := []string{"团队/项目/a.docx", "团队/项目/b.xlsx"}
+	if !reflect.DeepEqual(targets, want) {
+		t.Errorf("规划的导出结果为 %q，应为 %q", targets, want)
+	}
+}
+
+func TestPlanWithoutAllowedExportsEverything(t *testing.T) {
+	e := &Exporter{}
+	if !e.planned("团队", 1, "docx") {
+		t.Error("不是从计划导出时所有文件都应导出")
+	}
+}
@@ -0,0 +1,213 @@
+package kdocs
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"KingExporter/internal/global"
+	"KingExporter/pkg/office"
+	"github.com/samber/lo"
+)
+
+// PostProcessor 在文件下载完成后处理本地文件，例如生成 Markdown
+type PostProcessor interface {
+	// Match 判断是否处理 path 指向的文件
+	Match(path string) bool
+	Process(path string) error
+}
+
+// outputLister 由 PostProcessor 可选实现，返回处理 path 时会生成的文件，
+// 镜像模式移动或清理 path 时一并处理这些文件
+type outputLister interface {
+	Outputs(path string) []string
+}
+
+// outputNamer 由 PostProcessor 可选实现，返回处理名为 name 的导出结果时在同一文件夹中生成的名称，
+// 导出前与云文件一起检测重名。names 为该文件独占的名称，shared 为同一文件夹中的文件共用的目录
+type outputNamer interface {
+	OutputNames(name string) (names, shared []string)
+}
+
+// nameRulesUser 由 PostProcessor 可选实现，Exporter 传入导出使用的文件名规则，
+// 生成的文件名与云文件一样清理
+type nameRulesUser interface {
+	withNames(rules NameRules) PostProcessor
+}
+
+// AssetsDir 是 Markdown 中图片的存放目录
+const AssetsDir = "assets"
+
+// MarkdownProcessor 将 .docx（包括由 .otl 转码的文档）转换为同名 .md，图片提取到同目录的 assets/<文件名>/ 下
+type MarkdownProcessor struct{}
+
+func (MarkdownProcessor) Match(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".docx")
+}
+
+func (MarkdownProcessor) Process(path string) error {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	mdPath := strings.TrimSuffix(path, filepath.Ext(path)) + ".md"
+	assetsDir := filepath.Join(filepath.Dir(path), AssetsDir, base)
+	if err := office.DocxToMarkdown(path, mdPath, assetsDir, AssetsDir+"/"+base); err != nil {
+		return fmt.Errorf("转换 Markdown 失败 %s: %w", path, err)
+	}
+	return nil
+}
+
+// Outputs 返回生成的 Markdown 及图片目录
+func (MarkdownProcessor) Outputs(path string) []string {
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	return []string{
+		strings.TrimSuffix(path, filepath.Ext(path)) + ".md",
+		filepath.Join(filepath.Dir(path), AssetsDir, base),
+	}
+}
+
+// OutputNames 返回 <文件名>.md，图片目录 assets 由同一文件夹中的文档共用
+func (MarkdownProcessor) OutputNames(name string) ([]string, []string) {
+	return []string{strings.TrimSuffix(name, filepath.Ext(name)) + ".md"}, []string{AssetsDir}
+}
+
+// 表格提取的输出格式
+const (
+	SheetsFormatCSV  = "csv"
+	SheetsFormatJSON = "json"
+)
+
+// SpreadsheetProcessor 解析 .xlsx（包括由 .ksheet 转码的表格），
+// 为每个工作表写入 <文件名>.<工作表>.csv，或将所有工作表写入 <文件名>.json
+type SpreadsheetProcessor struct {
+	// Format 为 SheetsFormatCSV 或 SheetsFormatJSON
+	Format string
+	// CSV 控制 CSV 的编码及分隔符，JSON 固定为 UTF-8
+	CSV office.CSVOptions
+	// names 为工作表名称转换为文件名的规则，由 Exporter 设置
+	names NameRules
+}
+
+func (p SpreadsheetProcessor) withNames(rules NameRules) PostProcessor {
+	p.names = rules
+	return p
+}
+
+// Validate 检查输出格式及 CSV 参数
+func (p SpreadsheetProcessor) Validate() error {
+	if p.Format != SheetsFormatCSV && p.Format != SheetsFormatJSON {
+		return fmt.Errorf("不支持的表格提取格式 %s，可选 csv、json", p.Format)
+	}
+	return p.CSV.Validate()
+}
+
+func (p SpreadsheetProcessor) Match(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".xlsx")
+}
+
+func (p SpreadsheetProcessor) Process(path string) error {
+	sheets, err := office.ReadWorkbook(path)
+	if err != nil {
+		return fmt.Errorf("解析表格失败 %s: %w", path, err)
+	}
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+
+	if p.Format == SheetsFormatJSON {
+		data, err := json.MarshalIndent(struct {
+			Sheets []office.Sheet `json:"sheets"`
+		}{sheets}, "", "  ")
+		if err != nil {
+			return fmt.Errorf("序列化表格失败 %s: %w", path, err)
+		}
+		if err := os.WriteFile(base+".json", data, 0644); err != nil {
+			return fmt.Errorf("写入表格 JSON 失败 %s: %w", path, err)
+		}
+		return nil
+	}
+
+	files := p.sheetFiles(path, lo.Map(sheets, func(s office.Sheet, _ int) string { return s.Name }))
+	for i, sheet := range sheets {
+		if err := office.WriteCSV(files[i], sheet.Rows, p.CSV); err != nil {
+			return fmt.Errorf("写入工作表 %s 失败 %s: %w", sheet.Name, path, err)
+		}
+	}
+	return nil
+}
+
+// Outputs 返回生成的 JSON 或各工作表的 CSV。CSV 按工作簿中的工作表名称计算，
+// 不包括同目录中其他以 <文件名>. 开头的 .csv
+func (p SpreadsheetProcessor) Outputs(path string) []string {
+	base := strings.TrimSuffix(path, filepath.Ext(path))
+	if p.Format == SheetsFormatJSON {
+		return []string{base + ".json"}
+	}
+
+	names, err := office.SheetNames(path)
+	if err != nil {
+		global.Log.Warn("读取工作表名称失败 %s: %v", path, err)
+		return nil
+	}
+	return p.sheetFiles(path, names)
+}
+
+// OutputNames 返回提取的 JSON。CSV 的名称取决于下载后的工作表，不参与检测
+func (p SpreadsheetProcessor) OutputNames(name string) ([]string, []string) {
+	if p.Format != SheetsFormatJSON {
+		return nil, nil
+	}
+	return []string{strings.TrimSuffix(name, filepath.Ext(name)) + ".json"}, nil
+}
+
+// sheetFiles 返回各工作表提取为 <文件名>.<工作表>.csv 的路径，工作表名称按 NameRules 清理，
+// 清理后重名的工作表追加序号，超长的文件名按 MaxNameLength 截断
+func (p SpreadsheetProcessor) sheetFiles(path string, names []string) []string {
+	dir := filepath.Dir(path)
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	used := map[string]bool{}
+	files := make([]string, 0, len(names))
+	for i, sheet := range names {
+		if strings.TrimSpace(sheet) == "" {
+			sheet = "Sheet" + strconv.Itoa(i+1)
+		}
+		name := p.names.clean(sheet)
+		unique := name
+		for n := 2; used[p.names.key(unique)]; n++ {
+			unique = name + "_" + strconv.Itoa(n)
+		}
+		used[p.names.key(unique)] = true
+		files = append(files, filepath.Join(dir, p.names.shorten(base+"."+unique+".csv", false)))
+	}
+	return files
+}
+
+// needsPostProcess 判断是否有 PostProcessor 需要处理 path
+func (e *Exporter) needsPostProcess(path string) bool {
+	return lo.ContainsBy(e.postProcessors, func(p PostProcessor) bool { return p.Match(path) })
+}
+
+// outputNames 返回后处理为名为 name 的导出结果生成的名称及共用目录
+func (e *Exporter) outputNames(name string) (names, shared []string) {
+	for _, p := range e.postProcessors {
+		o, ok := p.(outputNamer)
+		if !ok || !p.Match(name) {
+			continue
+		}
+		n, s := o.OutputNames(name)
+		names = append(names, n...)
+		shared = append(shared, s...)
+	}
+	return names, shared
+}
+
+// postProcess 依次执行匹配的 PostProcessor，失败只记录日志
+func (e *Exporter) postProcess(path string) {
+	for _, p := range e.postProcessors {
+		if !p.Match(path) {
+			continue
+		}
+		if err := p.Process(path); err != nil {
+			global.Log.Error(err.Error())
+		}
+	}
+}
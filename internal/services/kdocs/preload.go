@@ -5,6 +5,27 @@ import (
 	"time"
 
 	"KingExporter/internal/global"
+	"KingExporter/internal/services/api"
+	"KingExporter/pkg/display"
+)
+
+const (
+	// DefaultPreloadTimeout 是单次转码等待时间的默认上限
+	DefaultPreloadTimeout = 10 * time.Minute
+
+	preloadBaseTimeout  = 10 * time.Second
+	preloadTimeoutPerMB = 5 * time.Second
+	preloadMinInterval  = 500 * time.Millisecond
+	preloadMaxInterval  = 5 * time.Second
+)
+
+// 转码任务的状态，finished 以外的终止状态需要重新创建任务
+const (
+	preloadFinished  = "finished"
+	preloadFailed    = "failed"
+	preloadError     = "error"
+	preloadCancelled = "cancelled"
+	preloadExpired   = "expired"
 )
 
 func (e *Exporter) preloadWorker(id int, st *state) {
@@ -15,61 +36,96 @@ func (e *Exporter) preloadWorker(id int, st *state) {
 			if !ok {
 				return
 			}
-			fmt.Printf("⌛️ Preload export %s\n", job.File.FName)
-			err := e.handlePreload(job, st)
+			// 达到下载上限后不再创建转码任务
+			if e.budget != nil && e.budget.exhausted() {
+				e.budget.skip()
+				e.record(job.Entry, errBudgetExceeded)
+				st.preloadWg.Done()
+				continue
+			}
+			display.Progress("⌛️ Preload export %s", job.File.FName)
+			err := e.handlePreload(&job, st)
 			if err != nil && job.RetryCount < job.MaxRetries {
 				job.RetryCount++
-				time.Sleep(time.Second)
-				st.preloadCh <- job
+				// 重新入队的任务同样计入 preloadWg，且不阻塞当前 worker
+				st.preloadWg.Add(1)
+				go func() {
+					time.Sleep(time.Second)
+					st.preloadCh <- job
+				}()
 			} else if err != nil {
+				if !verified(job.File, job.Format) {
+					err = fmt.Errorf("%w（导出为 %s 尚未验证，可能不受支持）", err, job.Format)
+				}
 				global.Log.Error(fmt.Sprintf("[Preload #%d] Failed to process %s after %d retries: %s",
 					id, job.File.FName, job.RetryCount, err))
+				e.record(job.Entry, err)
 			}
 			st.preloadWg.Done()
 		}
 	}
 }
 
-func (e *Exporter) handlePreload(job PreloadJob, st *state) error {
-	data, err := e.api.PreloadExport(job.File.ID, job.File.FName)
-	if err != nil {
-		global.Log.Error(e.logError("预导出文件失败", err, job.File, job.GroupID))
-		return err
-	}
-	if data.TaskID == "" {
-		err = fmt.Errorf("预导出文件，taskID 为空")
-		global.Log.Error("预导出文件失败", err)
-		return err
+// preloadTimeout 按文件大小计算转码的等待时间，不超过 e.preloadTimeout
+func (e *Exporter) preloadTimeout(f api.File) time.Duration {
+	timeout := preloadBaseTimeout + time.Duration(f.FSize>>20)*preloadTimeoutPerMB
+	return min(timeout, e.preloadMaxTimeout)
+}
+
+// handlePreload 创建转码任务并轮询进度，超时后保留任务 ID，重试时继续等待同一个任务
+func (e *Exporter) handlePreload(job *PreloadJob, st *state) error {
+	if job.TaskID == "" {
+		data, err := e.api.PreloadExport(job.File.ID, job.Format)
+		if err != nil {
+			global.Log.Error(e.logError("预导出文件失败", err, job.File, job.GroupID))
+			return err
+		}
+		if data.TaskID == "" {
+			err = fmt.Errorf("预导出文件，taskID 为空")
+			global.Log.Error("预导出文件失败: %v", err)
+			return err
+		}
+		job.TaskID, job.TaskType = data.TaskID, data.TaskType
 	}
 
-	ticker := time.NewTicker(500 * time.Millisecond)
-	defer ticker.Stop()
-	timeout := time.After(10 * time.Second)
+	interval := preloadMinInterval
+	deadline := time.Now().Add(e.preloadTimeout(job.File))
 
 	for {
-		select {
-		case <-timeout:
-			global.Log.Error("转码导出超时", job.File.FName, job.File.FSize)
-			return fmt.Errorf("转码导出失败")
-		case <-ticker.C:
-			result, err := e.api.ExportProgress(
-				job.File.ID,
-				data.TaskID,
-				data.TaskType,
-				e.api.GetFormat(job.File.FName),
-			)
-			if err != nil {
-				global.Log.Error(fmt.Sprintf("获取导出进度失败: %s", err), job.File.FSize, job.File.FName)
-				continue
-			}
-			if result.Status == "finished" {
-				st.downloadWg.Add(1)
-				st.downloadCh <- DownloadJob{
-					Url:      result.Data.Url,
-					FullPath: job.FullPath,
-				}
-				return nil
+		if time.Now().After(deadline) {
+			global.Log.Error("转码导出超时 %s fileSize: %d taskID: %s", job.File.FName, job.File.FSize, job.TaskID)
+			return fmt.Errorf("转码导出超时")
+		}
+		time.Sleep(interval)
+		// 指数退避，避免大文件转码时频繁轮询
+		interval = min(interval*3/2, preloadMaxInterval)
+
+		result, err := e.api.ExportProgress(
+			job.File.ID,
+			job.TaskID,
+			job.TaskType,
+			job.Format,
+		)
+		if err != nil {
+			global.Log.Error("获取导出进度失败: %s fileSize: %d fileName: %s", err, job.File.FSize, job.File.FName)
+			continue
+		}
+
+		switch result.Status {
+		case preloadFinished:
+			st.downloadWg.Add(1)
+			st.downloadCh <- DownloadJob{
+				Url:      result.Data.Url,
+				FullPath: job.FullPath,
+				Entry:    job.Entry,
+				File:     job.File,
 			}
+			return nil
+		case preloadFailed, preloadError, preloadCancelled, preloadExpired:
+			// 任务已终止，重试时重新创建
+			job.TaskID, job.TaskType = "", ""
+			global.Log.Error("转码任务终止 %s status: %s", job.File.FName, result.Status)
+			return fmt.Errorf("转码任务状态为 %s", result.Status)
 		}
 	}
 }
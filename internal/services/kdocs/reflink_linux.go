@@ -0,0 +1,27 @@
+package kdocs
+
+import (
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflink 通过 FICLONE 创建与 src 共享数据块的 dst，需要 Btrfs、XFS 等支持写时复制的文件系统
+func reflink(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlFileClone(int(out.Fd()), int(in.Fd())); err != nil {
+		out.Close()
+		os.Remove(dst)
+		return err
+	}
+	return out.Close()
+}
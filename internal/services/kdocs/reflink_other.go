@@ -0,0 +1,10 @@
+//go:build !linux
+
+package kdocs
+
+import "errors"
+
+// reflink 目前只支持 Linux，其他系统改为复制
+func reflink(src, dst string) error {
+	return errors.ErrUnsupported
+}
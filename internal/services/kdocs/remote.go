@@ -0,0 +1,60 @@
+package kdocs
+
+import (
+	"fmt"
+	"strings"
+
+	"KingExporter/internal/services/api"
+)
+
+// resolveRemotePath 从 group 根目录开始通过 Files 逐级查找远程路径，返回目标文件夹的 ID
+func resolveRemotePath(c *api.KDocsApi, groupID int, remotePath string) (int, error) {
+	folderID := 0
+	for _, name := range splitRemotePath(remotePath) {
+		files, err := c.Files(groupID, folderID)
+		if err != nil {
+			return 0, fmt.Errorf("获取目录文件失败 folderID %d: %w", folderID, err)
+		}
+
+		found := false
+		for _, f := range files {
+			if f.FType == "folder" && f.FName == name {
+				folderID = f.ID
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("远程路径 %s 不存在", remotePath)
+		}
+	}
+	return folderID, nil
+}
+
+// parentPath 沿 ParentID 向上查找，返回文件所在文件夹相对 group 根目录的路径
+func parentPath(c *api.KDocsApi, f api.File) (string, error) {
+	var names []string
+	for parentID := f.ParentID; parentID != 0; {
+		parent, err := c.FileInfo(parentID)
+		if err != nil {
+			return "", fmt.Errorf("获取上级目录失败 folderID %d: %w", parentID, err)
+		}
+		names = append([]string{parent.FName}, names...)
+		parentID = parent.ParentID
+	}
+	return strings.Join(names, "/"), nil
+}
+
+func splitRemotePath(remotePath string) []string {
+	var parts []string
+	for _, v := range strings.Split(remotePath, "/") {
+		if v != "" {
+			parts = append(parts, v)
+		}
+	}
+	return parts
+}
+
+func joinRemotePath(dir, name string) string {
+	return strings.TrimSuffix(dir, "/") + "/" + name
+}
@@ -0,0 +1,199 @@
+package kdocs
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"net/url"
+	"os"
+	"path"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
+)
+
+// S3Scheme 是对象存储目的地的 URL scheme，例如 s3://bucket/prefix
+const S3Scheme = "s3"
+
+const (
+	// s3PartSize 为分片上传的分片大小，超过时按分片上传
+	s3PartSize = 16 << 20
+	// s3ChecksumKey 为记录 SHA-256 的元数据名
+	s3ChecksumKey = "sha256"
+)
+
+// S3Options 配置兼容 S3 的对象存储
+type S3Options struct {
+	// Endpoint 为空时读取环境变量 AWS_ENDPOINT_URL，仍为空时使用 AWS S3。
+	// 以 http:// 开头时使用 HTTP 连接，例如本地 MinIO http://localhost:9000
+	Endpoint string
+	Region   string
+	// Insecure 为 true 时使用 HTTP 连接
+	Insecure bool
+}
+
+// s3Sink 将文件上传到 bucket 中 prefix 下。写入的内容先暂存到临时文件并计算 SHA-256，
+// Close 时连同元数据一次上传，暂存需要与单个文件相同的临时空间。
+//
+// 访问密钥依次读取 AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY、MINIO_ROOT_USER/MINIO_ROOT_PASSWORD
+// 及 ~/.aws/credentials
+type s3Sink struct {
+	client *minio.Client
+	bucket string
+	prefix string
+}
+
+// isS3URL 判断目的地是否为对象存储
+func isS3URL(dest string) bool {
+	return strings.HasPrefix(dest, S3Scheme+"://")
+}
+
+func newS3Sink(rawURL string, opts S3Options) (*s3Sink, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != S3Scheme || u.Host == "" {
+		return nil, fmt.Errorf("对象存储地址不合法 %s，格式为 s3://bucket/prefix", rawURL)
+	}
+
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+	if endpoint == "" {
+		endpoint = "s3.amazonaws.com"
+	}
+	secure := !opts.Insecure
+	if strings.HasPrefix(endpoint, "http://") {
+		secure = false
+	}
+	endpoint = strings.TrimSuffix(strings.TrimPrefix(strings.TrimPrefix(endpoint, "http://"), "https://"), "/")
+
+	client, err := minio.New(endpoint, &minio.Options{
+		Creds: credentials.NewChainCredentials([]credentials.Provider{
+			&credentials.EnvAWS{},
+			&credentials.EnvMinio{},
+			&credentials.FileAWSCredentials{},
+		}),
+		Secure: secure,
+		Region: opts.Region,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("创建对象存储客户端失败: %w", err)
+	}
+
+	exists, err := client.BucketExists(context.Background(), u.Host)
+	if err != nil {
+		return nil, fmt.Errorf("访问 bucket %s 失败: %w", u.Host, err)
+	}
+	if !exists {
+		return nil, fmt.Errorf("bucket %s 不存在", u.Host)
+	}
+
+	return &s3Sink{client: client, bucket: u.Host, prefix: strings.Trim(u.Path, "/")}, nil
+}
+
+func (d *s3Sink) key(name string) string {
+	return path.Join(d.prefix, name)
+}
+
+// MkdirAll 对象存储没有目录，无需创建
+func (d *s3Sink) MkdirAll(string) error { return nil }
+
+func (d *s3Sink) Create(name string, info FileInfo) (io.WriteCloser, error) {
+	f, err := os.CreateTemp("", "kingexporter-s3-")
+	if err != nil {
+		return nil, fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	return &s3Object{
+		d:    d,
+		key:  d.key(name),
+		meta: s3Metadata(info),
+		file: f,
+		hash: sha256.New(),
+	}, nil
+}
+
+// Finalize 元数据在上传时写入，对象的修改时间由服务端决定
+func (d *s3Sink) Finalize(string, FileInfo) error { return nil }
+
+func (d *s3Sink) Stat(name string) (FileInfo, error) {
+	stat, err := d.client.StatObject(context.Background(), d.bucket, d.key(name), minio.StatObjectOptions{})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return FileInfo{}, fmt.Errorf("%s: %w", name, fs.ErrNotExist)
+		}
+		return FileInfo{}, err
+	}
+	return FileInfo{Size: stat.Size, ModTime: stat.LastModified, Metadata: stat.UserMetadata}, nil
+}
+
+func (d *s3Sink) Remove(name string) error {
+	return d.client.RemoveObject(context.Background(), d.bucket, d.key(name), minio.RemoveObjectOptions{})
+}
+
+func (d *s3Sink) Close() error { return nil }
+
+// s3Metadata 将文件信息转换为对象元数据，元数据只能包含 ASCII，非 ASCII 的值按 URL 编码
+func s3Metadata(info FileInfo) map[string]string {
+	meta := map[string]string{}
+	for k, v := range info.Metadata {
+		meta[k] = url.PathEscape(v)
+	}
+	return meta
+}
+
+// s3Object 是待上传的对象，写入的内容暂存到 file，同时计算 SHA-256
+type s3Object struct {
+	d       *s3Sink
+	key     string
+	meta    map[string]string
+	file    *os.File
+	hash    hash.Hash
+	written int64
+}
+
+func (o *s3Object) Write(p []byte) (int, error) {
+	n, err := o.file.Write(p)
+	o.hash.Write(p[:n])
+	o.written += int64(n)
+	return n, err
+}
+
+// Close 上传暂存的内容，SHA-256 与其他元数据一起写入。超过 s3PartSize 时按分片上传
+func (o *s3Object) Close() error {
+	defer o.remove()
+	if _, err := o.file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("读取 %s 的暂存文件失败: %w", o.key, err)
+	}
+
+	meta := map[string]string{s3ChecksumKey: hex.EncodeToString(o.hash.Sum(nil))}
+	for k, v := range o.meta {
+		meta[k] = v
+	}
+	_, err := o.d.client.PutObject(context.Background(), o.d.bucket, o.key, o.file, o.written, minio.PutObjectOptions{
+		UserMetadata: meta,
+		PartSize:     s3PartSize,
+		// SHA-256 已在写入时计算，不再使用逐块签名，同时兼容不支持 aws-chunked 的 S3 实现
+		DisableContentSha256: true,
+	})
+	if err != nil {
+		return fmt.Errorf("上传 %s 失败: %w", o.key, err)
+	}
+	return nil
+}
+
+// CloseWithError 放弃上传
+func (o *s3Object) CloseWithError(error) error {
+	o.remove()
+	return nil
+}
+
+// remove 删除暂存文件
+func (o *s3Object) remove() {
+	o.file.Close()
+	os.Remove(o.file.Name())
+}
@@ -0,0 +1,226 @@
+package kdocs
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// s3Request 是 S3 桩服务收到的一个请求
+type s3Request struct {
+	method string
+	path   string
+	query  string
+	header http.Header
+	body   []byte
+}
+
+// s3Stub 模拟兼容 S3 的对象存储，只实现 s3Sink 用到的接口
+type s3Stub struct {
+	*httptest.Server
+	mu       sync.Mutex
+	requests []s3Request
+}
+
+func newS3Stub(t *testing.T) *s3Stub {
+	s := &s3Stub{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			t.Errorf("读取请求体失败: %v", err)
+		}
+		s.mu.Lock()
+		s.requests = append(s.requests, s3Request{
+			method: r.Method,
+			path:   r.URL.Path,
+			query:  r.URL.RawQuery,
+			header: r.Header.Clone(),
+			body:   body,
+		})
+		s.mu.Unlock()
+
+		switch {
+		case r.Method == http.MethodHead && r.URL.Path == "/bucket/":
+			// BucketExists
+		case r.Method == http.MethodPost && r.URL.Query().Has("uploads"):
+			fmt.Fprint(w, `<InitiateMultipartUploadResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Bucket>bucket</Bucket><UploadId>upload-1</UploadId></InitiateMultipartUploadResult>`)
+		case r.Method == http.MethodPost && r.URL.Query().Has("uploadId"):
+			fmt.Fprint(w, `<CompleteMultipartUploadResult xmlns="http://s3.amazonaws.com/doc/2006-03-01/"><Bucket>bucket</Bucket><ETag>"etag"</ETag></CompleteMultipartUploadResult>`)
+		case r.Method == http.MethodPut:
+			w.Header().Set("ETag", `"etag"`)
+		default:
+			t.Errorf("未预期的请求 %s %s", r.Method, r.URL)
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// objectRequests 返回对 key 的请求，不包括 BucketExists
+func (s *s3Stub) objectRequests(key string) []s3Request {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var requests []s3Request
+	for _, r := range s.requests {
+		if r.path == "/bucket/"+key {
+			requests = append(requests, r)
+		}
+	}
+	return requests
+}
+
+func newTestS3Sink(t *testing.T, s *s3Stub) *s3Sink {
+	t.Setenv("AWS_ACCESS_KEY_ID", "test")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "testsecret")
+	d, err := newS3Sink("s3://bucket/backup/kdocs", S3Options{Endpoint: s.URL, Region: "us-east-1"})
+	if err != nil {
+		t.Fatalf("newS3Sink: %v", err)
+	}
+	return d
+}
+
+func testFileInfo(size int64) FileInfo {
+	return FileInfo{
+		Size:    size,
+		ModTime: time.Unix(1700000000, 0),
+		Metadata: map[string]string{
+			"kdocs-file-id":     "42",
+			"kdocs-group-id":    "7",
+			"kdocs-remote-path": "/项目/报告.docx",
+			"kdocs-mtime":       "2023-11-14T22:13:20Z",
+		},
+	}
+}
+
+func writeObject(t *testing.T, d *s3Sink, name string, info FileInfo, content string) {
+	w, err := d.Create(name, info)
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := io.Copy(w, strings.NewReader(content)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+}
+
+func TestS3SinkPutObject(t *testing.T) {
+	s := newS3Stub(t)
+	d := newTestS3Sink(t, s)
+	content := "hello kdocs"
+	writeObject(t, d, "团队/报告.docx", testFileInfo(int64(len(content))), content)
+
+	requests := s.objectRequests("backup/kdocs/团队/报告.docx")
+	if len(requests) != 1 {
+		t.Fatalf("请求数为 %d，应只有一次 PutObject", len(requests))
+	}
+
+	put := requests[0]
+	if put.method != http.MethodPut || put.header.Get("X-Amz-Copy-Source") != "" {
+		t.Fatalf("请求应为 PutObject: %s %s", put.method, put.query)
+	}
+	if string(put.body) != content {
+		t.Errorf("上传内容 %q，应为 %q", put.body, content)
+	}
+	if got := put.header.Get("X-Amz-Content-Sha256"); got != "UNSIGNED-PAYLOAD" {
+		t.Errorf("上传时不应逐块签名，X-Amz-Content-Sha256 为 %q", got)
+	}
+	sum := sha256.Sum256([]byte(content))
+	wantMeta := map[string]string{
+		"X-Amz-Meta-Kdocs-File-Id":     "42",
+		"X-Amz-Meta-Kdocs-Group-Id":    "7",
+		"X-Amz-Meta-Kdocs-Remote-Path": "%2F%E9%A1%B9%E7%9B%AE%2F%E6%8A%A5%E5%91%8A.docx",
+		"X-Amz-Meta-Kdocs-Mtime":       "2023-11-14T22:13:20Z",
+		"X-Amz-Meta-Sha256":            hex.EncodeToString(sum[:]),
+	}
+	for k, v := range wantMeta {
+		if got := put.header.Get(k); got != v {
+			t.Errorf("PutObject 元数据 %s 为 %q，应为 %q", k, got, v)
+		}
+	}
+}
+
+func TestS3SinkUnknownSize(t *testing.T) {
+	s := newS3Stub(t)
+	d := newTestS3Sink(t, s)
+	content := strings.Repeat("x", 1024)
+	writeObject(t, d, "a.txt", testFileInfo(-1), content)
+
+	// 大小未知时按暂存的大小一次上传
+	requests := s.objectRequests("backup/kdocs/a.txt")
+	if len(requests) != 1 || requests[0].method != http.MethodPut {
+		t.Fatalf("请求为 %d 个，应只有一次 PutObject", len(requests))
+	}
+	if string(requests[0].body) != content {
+		t.Errorf("上传内容共 %d 字节，应为 %d 字节", len(requests[0].body), len(content))
+	}
+}
+
+func TestS3SinkMultipart(t *testing.T) {
+	s := newS3Stub(t)
+	d := newTestS3Sink(t, s)
+	content := strings.Repeat("x", s3PartSize+1)
+	writeObject(t, d, "big.bin", testFileInfo(-1), content)
+
+	// 超过分片大小时按分片上传，元数据及校验和在创建分片上传时写入
+	sum := sha256.Sum256([]byte(content))
+	var parts []byte
+	var initiated, completed bool
+	for _, r := range s.objectRequests("backup/kdocs/big.bin") {
+		switch {
+		case r.method == http.MethodPost && strings.HasPrefix(r.query, "uploads"):
+			initiated = true
+			if got := r.header.Get("X-Amz-Meta-Kdocs-File-Id"); got != "42" {
+				t.Errorf("分片上传元数据 kdocs-file-id 为 %q", got)
+			}
+			if got := r.header.Get("X-Amz-Meta-Sha256"); got != hex.EncodeToString(sum[:]) {
+				t.Errorf("分片上传元数据 sha256 为 %q", got)
+			}
+		case r.method == http.MethodPut && strings.Contains(r.query, "partNumber"):
+			parts = append(parts, r.body...)
+		case r.method == http.MethodPost:
+			completed = true
+		default:
+			t.Errorf("未预期的请求 %s %s", r.method, r.query)
+		}
+	}
+	if !initiated || !completed {
+		t.Fatalf("超过分片大小的文件应通过分片上传")
+	}
+	if string(parts) != content {
+		t.Errorf("分片内容共 %d 字节，应为 %d 字节", len(parts), len(content))
+	}
+}
+
+func TestS3SinkCloseWithError(t *testing.T) {
+	s := newS3Stub(t)
+	d := newTestS3Sink(t, s)
+	w, err := d.Create("a.txt", testFileInfo(3))
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := w.Write([]byte("abc")); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	spool := w.(*s3Object).file.Name()
+	if err := w.(*s3Object).CloseWithError(io.ErrUnexpectedEOF); err != nil {
+		t.Fatalf("CloseWithError: %v", err)
+	}
+
+	if requests := s.objectRequests("backup/kdocs/a.txt"); len(requests) != 0 {
+		t.Errorf("放弃写入时不应上传，请求数为 %d", len(requests))
+	}
+	if _, err := os.Stat(spool); !os.IsNotExist(err) {
+		t.Errorf("暂存文件 %s 应已删除: %v", spool, err)
+	}
+}
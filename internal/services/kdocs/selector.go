@@ -0,0 +1,109 @@
+package kdocs
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"KingExporter/internal/services/api"
+	"github.com/samber/lo"
+)
+
+// personalGroupTypes 是未指定任何条件时默认导出的个人空间类型
+var personalGroupTypes = []string{"special", "corpspecial"}
+
+// GroupSelector 描述需要处理的 group，导出和 groups 子命令共用同一套选择逻辑。
+//
+// 名称匹配默认使用 glob（如 "项目*"），以 / 包裹时按正则处理（如 "/^研发.+组$/"）。
+type GroupSelector struct {
+	// All 选择所有 group
+	All bool
+	// IDs 与 Names 命中任意一项即被选中
+	IDs   []int
+	Names []string
+	// Types 只保留指定类型的 group
+	Types []string
+
+	ExcludeIDs   []int
+	ExcludeNames []string
+	ExcludeTypes []string
+}
+
+// HasInclude 判断是否设置了 All、ID、名称或类型等选择条件，只有排除条件时返回 false
+func (s GroupSelector) HasInclude() bool {
+	return s.All || len(s.IDs) > 0 || len(s.Names) > 0 || len(s.Types) > 0
+}
+
+// Select 从 groups 中筛选出符合条件的 group，未设置条件时只选择个人空间
+func (s GroupSelector) Select(groups []api.Group) ([]api.Group, error) {
+	names, err := compileNamePatterns(s.Names)
+	if err != nil {
+		return nil, err
+	}
+	excludeNames, err := compileNamePatterns(s.ExcludeNames)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, id := range s.IDs {
+		if !lo.ContainsBy(groups, func(g api.Group) bool { return g.ID == id }) {
+			return nil, fmt.Errorf("groupID: %d 不存在", id)
+		}
+	}
+
+	hasInclude := len(s.IDs) > 0 || len(names) > 0
+	var selected []api.Group
+	for _, g := range groups {
+		switch {
+		case s.All:
+		case hasInclude:
+			if !lo.Contains(s.IDs, g.ID) && !matchAny(names, g.Name) {
+				continue
+			}
+		case len(s.Types) == 0:
+			if !lo.Contains(personalGroupTypes, g.Type) {
+				continue
+			}
+		}
+
+		if len(s.Types) > 0 && !lo.Contains(s.Types, g.Type) {
+			continue
+		}
+		if lo.Contains(s.ExcludeIDs, g.ID) || lo.Contains(s.ExcludeTypes, g.Type) || matchAny(excludeNames, g.Name) {
+			continue
+		}
+		selected = append(selected, g)
+	}
+
+	return selected, nil
+}
+
+type namePattern func(name string) bool
+
+func compileNamePatterns(patterns []string) ([]namePattern, error) {
+	var result []namePattern
+	for _, p := range patterns {
+		if len(p) > 1 && strings.HasPrefix(p, "/") && strings.HasSuffix(p, "/") {
+			re, err := regexp.Compile(p[1 : len(p)-1])
+			if err != nil {
+				return nil, fmt.Errorf("group 名称正则 %s 不合法: %w", p, err)
+			}
+			result = append(result, re.MatchString)
+			continue
+		}
+
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, fmt.Errorf("group 名称通配符 %s 不合法: %w", p, err)
+		}
+		result = append(result, func(name string) bool {
+			ok, _ := path.Match(p, name)
+			return ok
+		})
+	}
+	return result, nil
+}
+
+func matchAny(patterns []namePattern, name string) bool {
+	return lo.ContainsBy(patterns, func(match namePattern) bool { return match(name) })
+}
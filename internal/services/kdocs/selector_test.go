@@ -0,0 +1,82 @@
+package kdocs
+
+import (
+	"reflect"
+	"testing"
+
+	"KingExporter/internal/services/api"
+)
+
+var testGroups = []api.Group{
+	{ID: 1, Name: "我的云文档", Type: "special"},
+	{ID: 2, Name: "企业云文档", Type: "corpspecial"},
+	{ID: 3, Name: "项目A", Type: "normal"},
+	{ID: 4, Name: "项目B归档", Type: "normal"},
+	{ID: 5, Name: "研发一组", Type: "corpnormal"},
+}
+
+func TestGroupSelectorSelect(t *testing.T) {
+	tests := []struct {
+		name     string
+		selector GroupSelector
+		want     []int
+	}{
+		{"未设置条件时只选择个人空间", GroupSelector{}, []int{1, 2}},
+		{"全部", GroupSelector{All: true}, []int{1, 2, 3, 4, 5}},
+		{"按 ID", GroupSelector{IDs: []int{3, 5}}, []int{3, 5}},
+		{"按 glob 名称", GroupSelector{Names: []string{"项目*"}}, []int{3, 4}},
+		{"按正则名称", GroupSelector{Names: []string{"/^研发.+组$/"}}, []int{5}},
+		{"ID 与名称命中任意一项", GroupSelector{IDs: []int{1}, Names: []string{"项目A"}}, []int{1, 3}},
+		{"按类型", GroupSelector{Types: []string{"normal"}}, []int{3, 4}},
+		{"名称与类型同时满足", GroupSelector{Names: []string{"*组", "项目*"}, Types: []string{"corpnormal"}}, []int{5}},
+		{"全部中排除名称", GroupSelector{All: true, ExcludeNames: []string{"/归档$/"}}, []int{1, 2, 3, 5}},
+		{"全部中排除 ID 及类型", GroupSelector{All: true, ExcludeIDs: []int{3}, ExcludeTypes: []string{"special"}}, []int{2, 4, 5}},
+		{"只有排除条件时从个人空间中排除", GroupSelector{ExcludeIDs: []int{1}}, []int{2}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			groups, err := tt.selector.Select(testGroups)
+			if err != nil {
+				t.Fatalf("Select: %v", err)
+			}
+			var ids []int
+			for _, g := range groups {
+				ids = append(ids, g.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.want) {
+				t.Errorf("选中 %v，应为 %v", ids, tt.want)
+			}
+		})
+	}
+}
+
+func TestGroupSelectorSelectErrors(t *testing.T) {
+	for _, s := range []GroupSelector{
+		{IDs: []int{99}},
+		{Names: []string{"/[/"}},
+		{ExcludeNames: []string{"[项目"}},
+	} {
+		if _, err := s.Select(testGroups); err == nil {
+			t.Errorf("%+v 应返回错误", s)
+		}
+	}
+}
+
+func TestGroupSelectorHasInclude(t *testing.T) {
+	tests := []struct {
+		selector GroupSelector
+		want     bool
+	}{
+		{GroupSelector{}, false},
+		{GroupSelector{ExcludeIDs: []int{1}, ExcludeNames: []string{"x"}, ExcludeTypes: []string{"normal"}}, false},
+		{GroupSelector{All: true}, true},
+		{GroupSelector{IDs: []int{1}}, true},
+		{GroupSelector{Names: []string{"x"}}, true},
+		{GroupSelector{Types: []string{"normal"}}, true},
+	}
+	for _, tt := range tests {
+		if got := tt.selector.HasInclude(); got != tt.want {
+			t.Errorf("%+v HasInclude() = %v，应为 %v", tt.selector, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package kdocs
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"KingExporter/internal/services/api"
+	"github.com/go-resty/resty/v2"
+)
+
+// DestinationLocal 表示写入本地下载目录，是默认的导出目的地
+const DestinationLocal = "local"
+
+// Sink 是导出结果的写入位置。processFile 及 downloadWorker 只通过 Sink 创建目录及写入文件，
+// 默认实现写入本地下载目录，也可以通过 ExportOptions.Sink 接入其他存储。
+//
+// name 均为相对导出根目录、以 / 分隔的路径。各方法会被多个 worker 并发调用。
+type Sink interface {
+	// MkdirAll 创建目录，没有目录概念的存储可以忽略
+	MkdirAll(name string) error
+	// Create 打开写入 name 的 writer，Close 后写入生效。
+	// 写入失败时，writer 实现了 CloseWithError(error) error 则调用它放弃写入，否则 Close 后调用 Remove
+	Create(name string, info FileInfo) (io.WriteCloser, error)
+	// Finalize 在文件或目录的内容全部写入后调用，用于设置修改时间等，目录在其中所有文件之后处理
+	Finalize(name string, info FileInfo) error
+	// Stat 返回已写入文件的信息，不存在时返回的错误满足 errors.Is(err, fs.ErrNotExist)
+	Stat(name string) (FileInfo, error)
+	// Remove 删除已写入的文件
+	Remove(name string) error
+	// Close 在导出结束后调用
+	Close() error
+}
+
+// FileInfo 描述写入 Sink 的文件
+type FileInfo struct {
+	// Size 为 -1 时表示大小未知
+	Size    int64
+	ModTime time.Time
+	// Metadata 为云文件的 ID、远程路径等信息，支持附加元数据的存储（如对象存储）可以一并保存
+	Metadata map[string]string
+}
+
+// localPather 由写入本地文件系统的 Sink 实现，后处理可直接读取该路径
+type localPather interface {
+	LocalPath(name string) string
+}
+
+// NewLocalSink 返回写入本地目录 root 的 Sink
+func NewLocalSink(root string) Sink {
+	return &localSink{root: root}
+}
+
+type localSink struct {
+	root string
+}
+
+func (s *localSink) LocalPath(name string) string {
+	return filepath.Join(s.root, filepath.FromSlash(name))
+}
+
+func (s *localSink) MkdirAll(name string) error {
+	dir := s.LocalPath(name)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create directory %s: %v", dir, err)
+	}
+	return nil
+}
+
+func (s *localSink) Create(name string, _ FileInfo) (io.WriteCloser, error) {
+	p := s.LocalPath(name)
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return nil, err
+	}
+	f, err := os.Create(p)
+	if err != nil {
+		return nil, err
+	}
+	return &localFile{File: f}, nil
+}
+
+// Finalize 将修改时间设置为云端的修改时间
+func (s *localSink) Finalize(name string, info FileInfo) error {
+	if info.ModTime.IsZero() {
+		return nil
+	}
+	p := s.LocalPath(name)
+	if err := os.Chtimes(p, info.ModTime, info.ModTime); err != nil {
+		return fmt.Errorf("设置修改时间失败 %s: %w", p, err)
+	}
+	return nil
+}
+
+func (s *localSink) Stat(name string) (FileInfo, error) {
+	stat, err := os.Stat(s.LocalPath(name))
+	if err != nil {
+		return FileInfo{}, err
+	}
+	return FileInfo{Size: stat.Size(), ModTime: stat.ModTime()}, nil
+}
+
+func (s *localSink) Remove(name string) error {
+	return os.Remove(s.LocalPath(name))
+}
+
+func (s *localSink) Close() error { return nil }
+
+// localFile 写入失败时删除不完整的文件
+type localFile struct {
+	*os.File
+}
+
+func (f *localFile) CloseWithError(error) error {
+	f.File.Close()
+	return os.Remove(f.Name())
+}
+
+// sinkName 返回本地路径在 Sink 中的名称
+func (e *Exporter) sinkName(p string) string {
+	rel, err := filepath.Rel(e.downloadDir, p)
+	if err != nil {
+		rel = p
+	}
+	return strings.TrimPrefix(filepath.ToSlash(rel), "./")
+}
+
+// mkdirAll 在 Sink 中创建目录
+func (e *Exporter) mkdirAll(dir string) error {
+	return e.sink.MkdirAll(e.sinkName(dir))
+}
+
+// writeFile 写入导出产生的旁路文件，例如元数据、评论及清单
+func (e *Exporter) writeFile(p string, data []byte) error {
+	return writeTo(e.sink, e.sinkName(p), bytes.NewReader(data), FileInfo{Size: int64(len(data)), ModTime: time.Now()})
+}
+
+// writeTo 将 r 的内容写入 s 中的 name，失败时放弃写入
+func writeTo(s Sink, name string, r io.Reader, info FileInfo) error {
+	w, err := s.Create(name, info)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		if a, ok := w.(interface{ CloseWithError(error) error }); ok {
+			a.CloseWithError(err)
+		} else {
+			w.Close()
+			s.Remove(name)
+		}
+		return err
+	}
+	return w.Close()
+}
+
+// fileInfoOf 返回云文件写入 Sink 时的文件信息
+func fileInfoOf(f api.File, entry ManifestEntry) FileInfo {
+	info := FileInfo{
+		Size:    -1,
+		ModTime: modTimeOf(f),
+		Metadata: map[string]string{
+			"kdocs-file-id":     strconv.Itoa(entry.FileID),
+			"kdocs-group-id":    strconv.Itoa(entry.GroupID),
+			"kdocs-remote-path": entry.RemotePath,
+		},
+	}
+	if f.MTime > 0 {
+		info.Metadata["kdocs-mtime"] = formatUnix(f.MTime)
+	}
+	return info
+}
+
+// fetch 下载 job 并写入 Sink。
+//
+// Sink 不在本地文件系统且文件需要后处理时，先下载到临时目录，处理完成后连同生成的文件一起写入；
+// 其余情况直接将下载流写入 Sink
+func (e *Exporter) fetch(job DownloadJob) error {
+	name := e.sinkName(job.FullPath)
+	info := fileInfoOf(job.File, job.Entry)
+
+	if local, ok := e.sink.(localPather); ok || !e.needsPostProcess(job.FullPath) {
+		if err := stream(job.Url, e.sink, name, info); err != nil {
+			return err
+		}
+		if ok {
+			e.postProcess(local.LocalPath(name))
+		}
+		return nil
+	}
+
+	spool, err := os.MkdirTemp("", "kingexporter-spool-")
+	if err != nil {
+		return fmt.Errorf("创建临时目录失败: %w", err)
+	}
+	defer os.RemoveAll(spool)
+
+	base := path.Base(name)
+	if err := stream(job.Url, NewLocalSink(spool), base, info); err != nil {
+		return err
+	}
+	e.postProcess(filepath.Join(spool, base))
+	return e.putTree(spool, path.Dir(name), base, info)
+}
+
+// stream 将 url 的响应体写入 s 中的 name
+func stream(url string, s Sink, name string, info FileInfo) error {
+	resp, err := resty.New().R().SetDoNotParseResponse(true).Get(url)
+	if err != nil {
+		return err
+	}
+	body := resp.RawBody()
+	defer body.Close()
+	if resp.IsError() {
+		return fmt.Errorf("unexpected status %s", resp.Status())
+	}
+	if info.Size < 0 {
+		info.Size = resp.RawResponse.ContentLength
+	}
+	return writeTo(s, name, body, info)
+}
+
+// putTree 将临时目录 dir 中的所有文件写入 Sink 的 prefix 目录下。
+// 下载的文件 base 使用 info，后处理生成的文件使用本地修改时间
+func (e *Exporter) putTree(dir, prefix, base string, info FileInfo) error {
+	return filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		rel, err := filepath.Rel(dir, p)
+		if err != nil {
+			return err
+		}
+		stat, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fi := FileInfo{Size: stat.Size(), ModTime: stat.ModTime(), Metadata: info.Metadata}
+		if filepath.ToSlash(rel) == base {
+			fi.ModTime = info.ModTime
+		}
+
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		return writeTo(e.sink, path.Join(prefix, filepath.ToSlash(rel)), f, fi)
+	})
+}
+
+// modTimeOf 返回云文件的修改时间，没有时返回零值
+func modTimeOf(f api.File) time.Time {
+	if f.MTime <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(f.MTime, 0)
+}
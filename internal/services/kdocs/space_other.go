@@ -0,0 +1,10 @@
+//go:build !linux && !darwin && !freebsd && !windows
+
+package kdocs
+
+import "errors"
+
+// freeSpace 在不支持的系统上返回错误，跳过剩余空间检查
+func freeSpace(dir string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
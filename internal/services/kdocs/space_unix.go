@@ -0,0 +1,14 @@
+//go:build linux || darwin || freebsd
+
+package kdocs
+
+import "golang.org/x/sys/unix"
+
+// freeSpace 返回 dir 所在文件系统中当前用户可用的字节数
+func freeSpace(dir string) (uint64, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(dir, &st); err != nil {
+		return 0, err
+	}
+	return uint64(st.Bavail) * uint64(st.Bsize), nil
+}
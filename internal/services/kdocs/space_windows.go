@@ -0,0 +1,16 @@
+package kdocs
+
+import "golang.org/x/sys/windows"
+
+// freeSpace 返回 dir 所在磁盘中当前用户可用的字节数，已考虑磁盘配额
+func freeSpace(dir string) (uint64, error) {
+	p, err := windows.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var free uint64
+	if err := windows.GetDiskFreeSpaceEx(p, &free, nil, nil); err != nil {
+		return 0, err
+	}
+	return free, nil
+}
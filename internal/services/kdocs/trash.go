@@ -0,0 +1,74 @@
+package kdocs
+
+import (
+	"fmt"
+
+	"KingExporter/internal/global"
+	"KingExporter/internal/services/api"
+	"github.com/samber/lo"
+)
+
+// TrashDir 是回收站文件在 group 目录下的存放位置
+const TrashDir = ".trash"
+
+// processTrash 将 group 回收站中的文件导出到 .trash/<原路径> 下
+func (e *Exporter) processTrash(groupID int, st *state) error {
+	files, err := listing(e, trashKey(groupID), func() ([]api.TrashFile, error) { return e.api.Trash(groupID) })
+	if err != nil {
+		return fmt.Errorf("获取回收站文件失败 groupID %d: %w", groupID, err)
+	}
+
+	suffixes := e.trashSuffixes(files)
+	for _, f := range files {
+		if f.FType == "folder" {
+			continue
+		}
+		if err := e.processTrashFile(f, groupID, suffixes[f.OriginalPath], st); err != nil {
+			global.Log.Error(fmt.Sprintf("处理回收站文件失败 %s: %v", f.FName, err))
+		}
+	}
+	return nil
+}
+
+// trashSuffixes 返回回收站中重名文件的后缀，原路径相同的文件之间检测重名，键为原路径
+func (e *Exporter) trashSuffixes(files []api.TrashFile) map[string]map[int]string {
+	byPath := lo.GroupBy(lo.Filter(files, func(f api.TrashFile, _ int) bool { return f.FType != "folder" }),
+		func(f api.TrashFile) string { return f.OriginalPath })
+	suffixes := map[string]map[int]string{}
+	for p, group := range byPath {
+		suffixes[p] = collisionSuffixes(lo.Map(group, func(f api.TrashFile, _ int) api.File { return f.File }), e.names, e.exportNames)
+	}
+	return suffixes
+}
+
+// trashLocation 返回回收站文件在 group 目录下的位置 .trash/<原路径>
+func trashLocation(f api.TrashFile, rules NameRules) location {
+	return remoteLocation(append([]string{TrashDir}, splitRemotePath(f.OriginalPath)...), rules)
+}
+
+// processTrashFile 与云文件一样由 Handler 导出回收站文件，suffixes 为同一原路径下重名文件的后缀
+func (e *Exporter) processTrashFile(f api.TrashFile, groupID int, suffixes map[int]string, st *state) error {
+	if e.exhausted(st) {
+		e.budget.skip()
+		return nil
+	}
+	if !e.planned(e.mirrorScope(st), f.ID, "") {
+		if e.mirror != nil {
+			e.mirror.keep(e.mirrorScope(st), f.ID)
+		}
+		return nil
+	}
+
+	dir := trashLocation(f, e.names)
+	dir.suffixes = suffixes
+	return e.handle(&HandleContext{
+		API:     e.api,
+		File:    f.File,
+		GroupID: groupID,
+		Convert: e.convert,
+		Trash:   &f,
+		e:       e,
+		st:      st,
+		dir:     dir,
+	})
+}
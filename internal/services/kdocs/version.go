@@ -0,0 +1,104 @@
+package kdocs
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"KingExporter/internal/global"
+	"KingExporter/internal/services/api"
+)
+
+// 历史版本的存放方式
+const (
+	// VersionsLayoutSuffix 与当前版本放在同一目录，命名为 name.v<N>.<ext>
+	VersionsLayoutSuffix = "suffix"
+	// VersionsLayoutDir 放在 .versions/<file>/ 目录下
+	VersionsLayoutDir = "dir"
+)
+
+const VersionsDir = ".versions"
+
+// versionPath 返回历史版本在本地的路径，fileName 为当前版本的本地文件名。
+// suffix 方式下 name.v<N>.<ext> 与文件夹中的导出结果重名时，追加历史版本 ID 作为后缀
+func (e *Exporter) versionPath(dir location, fileName string, v api.FileVersion) string {
+	ext := filepath.Ext(fileName)
+	if e.versionsLayout == VersionsLayoutDir {
+		return filepath.Join(dir.local, VersionsDir, fileName, fmt.Sprintf("v%d%s", v.Version, ext))
+	}
+	name := fmt.Sprintf("%s.v%d%s", strings.TrimSuffix(fileName, ext), v.Version, ext)
+	suffix := ""
+	if dir.taken[e.names.key(name)] {
+		suffix = fmt.Sprintf(" (%d)", v.ID)
+	}
+	return filepath.Join(dir.local, e.names.localName(name, suffix, false))
+}
+
+// processVersions 下载文件的所有历史版本
+func (e *Exporter) processVersions(f api.File, groupID int, loc location, st *state) error {
+	if isNative(f) {
+		// 金山文档在线格式的历史版本无法直接下载
+		global.Log.Warn("跳过在线文档的历史版本 %s", f.FName)
+		return nil
+	}
+
+	versions, err := e.api.Versions(groupID, f.ID)
+	if err != nil {
+		return fmt.Errorf("获取历史版本失败 fileID %d: %w", f.ID, err)
+	}
+
+	fileName := e.names.localName(f.FName, loc.suffixes[f.ID], false)
+	for _, v := range versions {
+		fullPath := filepath.Join(st.downloadDir, e.versionPath(loc, fileName, v))
+		if err := e.mkdirAll(filepath.Dir(fullPath)); err != nil {
+			return err
+		}
+
+		entry := e.newEntry(f, groupID, loc.remote, fullPath)
+		entry.Size = v.FSize
+		entry.Version = v.Version
+		entry.VersionAuthor = v.Modifier.Name
+		if v.MTime > 0 {
+			entry.VersionTime = time.Unix(v.MTime, 0).Format(time.RFC3339)
+		}
+		// 历史版本不会再修改，镜像模式下已导出的版本不再下载
+		if e.reuseVersion(entry, v, st) {
+			continue
+		}
+
+		item, err := e.api.GetVersionDownloadUrl(groupID, f.ID, v.ID)
+		if err == nil && item.Url == "" {
+			err = errors.New("下载地址为空")
+		}
+		if err != nil {
+			global.Log.Error(e.logError(fmt.Sprintf("获取历史版本 v%d 下载地址失败", v.Version), err, f, groupID))
+			e.record(entry, err)
+			continue
+		}
+		vf := f
+		vf.FSize, vf.MTime, vf.Modifier = v.FSize, v.MTime, v.Modifier
+		st.downloadWg.Add(1)
+		st.downloadCh <- DownloadJob{Url: item.Url, FullPath: fullPath, Entry: entry, File: vf}
+	}
+	return nil
+}
+
+// reuseVersion 在镜像模式下复用上次导出的历史版本，target 为 v<N>
+func (e *Exporter) reuseVersion(entry ManifestEntry, v api.FileVersion, st *state) bool {
+	if e.mirror == nil {
+		return false
+	}
+	if !e.mirror.reuse(mirrorEntry{
+		Scope:  e.mirrorScope(st),
+		FileID: entry.FileID,
+		Target: fmt.Sprintf("v%d", v.Version),
+		Path:   entry.LocalPath,
+		MTime:  v.MTime,
+	}) {
+		return false
+	}
+	e.record(entry, nil)
+	return true
+}
@@ -0,0 +1,97 @@
+package kdocs
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"KingExporter/internal/global"
+	"KingExporter/internal/services/api"
+	"KingExporter/pkg/display"
+	"github.com/samber/lo"
+)
+
+// 不属于任何 group 的文件列表，导出时作为虚拟 group 处理
+const (
+	VirtualShared  = "Shared With Me"
+	VirtualStarred = "Starred"
+	VirtualRecent  = "Recent"
+)
+
+type virtualGroup struct {
+	name string
+	list func() ([]api.LinkedFile, error)
+	// byOwner 为 true 时按文件所有者分目录存放
+	byOwner bool
+}
+
+func (e *Exporter) virtualGroups() []virtualGroup {
+	var groups []virtualGroup
+	if e.includeShared {
+		groups = append(groups, virtualGroup{name: VirtualShared, list: e.api.SharedWithMe, byOwner: true})
+	}
+	if e.includeStarred {
+		groups = append(groups, virtualGroup{name: VirtualStarred, list: e.api.Starred})
+	}
+	if e.includeRecent {
+		groups = append(groups, virtualGroup{name: VirtualRecent, list: e.api.Recent})
+	}
+	return groups
+}
+
+// owner 返回条目所在的所有者目录，不按所有者分目录时为空
+func (v virtualGroup) owner(item api.LinkedFile) string {
+	if !v.byOwner {
+		return ""
+	}
+	if item.Owner.Name == "" {
+		return "unknown"
+	}
+	return item.Owner.Name
+}
+
+// virtualDirs 返回各所有者目录的 location，同一所有者目录中的条目之间检测重名
+func (e *Exporter) virtualDirs(v virtualGroup, items []api.LinkedFile) map[string]location {
+	dirs := map[string]location{}
+	for name, group := range lo.GroupBy(items, v.owner) {
+		dir := remoteLocation(splitRemotePath(name), e.names)
+		dirs[name] = e.resolveNames(dir, lo.Map(group, func(item api.LinkedFile, _ int) api.File { return item.File }))
+	}
+	return dirs
+}
+
+// exportVirtual 导出虚拟 group 中的条目，条目所在的真实 group 用于获取下载地址
+func (e *Exporter) exportVirtual(v virtualGroup) {
+	items, err := listing(e, virtualKey(v.name), v.list)
+	if err != nil {
+		err = fmt.Errorf("获取 %s 列表失败: %w", v.name, err)
+		global.Log.Error(err.Error())
+		display.PrintError(err.Error())
+		return
+	}
+
+	_ = e.run(e.groupDir(v.name), nil, func(st *state) error {
+		dirs := e.virtualDirs(v, items)
+		for _, item := range items {
+			dir := dirs[v.owner(item)]
+			if item.FType == "folder" {
+				folder := dir.child(item.File, e.names)
+				e.processFolderMeta(item.File, filepath.Join(st.downloadDir, folder.local), st)
+				if err := e.processFolder(item.GroupID, item.ID, folder, st); err != nil {
+					global.Log.Error(fmt.Sprintf("处理文件夹失败 %s: %v", item.FName, err))
+					if e.mirror != nil {
+						e.mirror.markIncomplete(e.mirrorScope(st))
+					}
+				}
+				continue
+			}
+			if err := e.processFile(item.File, item.GroupID, dir, st); err != nil {
+				global.Log.Error(fmt.Sprintf("处理文件失败 %s: %v", item.FName, err))
+			}
+		}
+		if e.mirror != nil {
+			e.mirror.markScanned(e.mirrorScope(st))
+		}
+		return nil
+	})
+	display.Progress("✅ %s 文档导出完成", v.name)
+}
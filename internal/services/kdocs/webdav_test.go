@@ -0,0 +1,179 @@
+package kdocs
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// webDAVStub 模拟 Nextcloud 的 WebDAV 接口，记录 MKCOL 及 PUT 请求
+type webDAVStub struct {
+	*httptest.Server
+	mu    sync.Mutex
+	dirs  []string
+	files map[string]webDAVUpload
+}
+
+type webDAVUpload struct {
+	body          string
+	mtime         string
+	contentLength int64
+}
+
+func newWebDAVStub(t *testing.T) *webDAVStub {
+	s := &webDAVStub{files: map[string]webDAVUpload{}}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "alice" || pass != "secret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !strings.HasPrefix(r.URL.Path, "/dav/files/alice") {
+			t.Errorf("请求路径 %s 不在导出根目录中", r.URL.Path)
+		}
+		name := strings.TrimPrefix(r.URL.Path, "/dav/files/alice/")
+
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		switch r.Method {
+		case "PROPFIND":
+			w.WriteHeader(http.StatusMultiStatus)
+		case "MKCOL":
+			for _, dir := range s.dirs {
+				if dir == name {
+					w.WriteHeader(http.StatusMethodNotAllowed)
+					return
+				}
+			}
+			// 父目录不存在时返回 409
+			if i := strings.LastIndex(name, "/"); i > 0 && !s.hasDir(name[:i]) {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			s.dirs = append(s.dirs, name)
+			w.WriteHeader(http.StatusCreated)
+		case http.MethodPut:
+			if i := strings.LastIndex(name, "/"); i > 0 && !s.hasDir(name[:i]) {
+				w.WriteHeader(http.StatusConflict)
+				return
+			}
+			body, _ := io.ReadAll(r.Body)
+			s.files[name] = webDAVUpload{body: string(body), mtime: r.Header.Get("X-OC-MTime"), contentLength: r.ContentLength}
+			if r.Header.Get("X-OC-MTime") != "" {
+				w.Header().Set("X-OC-MTime", "accepted")
+			}
+			w.WriteHeader(http.StatusCreated)
+		default:
+			t.Errorf("未预期的请求 %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotImplemented)
+		}
+	}))
+	t.Cleanup(s.Close)
+	return s
+}
+
+func (s *webDAVStub) hasDir(name string) bool {
+	for _, dir := range s.dirs {
+		if dir == name {
+			return true
+		}
+	}
+	return false
+}
+
+func newTestWebDAVSink(t *testing.T, s *webDAVStub) *webDAVSink {
+	d, err := newWebDAVSink("dav://alice:secret@" + strings.TrimPrefix(s.URL, "http://") + "/dav/files/alice/")
+	if err != nil {
+		t.Fatalf("newWebDAVSink: %v", err)
+	}
+	return d
+}
+
+func TestWebDAVSinkRejectsBadCredentials(t *testing.T) {
+	s := newWebDAVStub(t)
+	if _, err := newWebDAVSink("dav://alice:wrong@" + strings.TrimPrefix(s.URL, "http://") + "/dav/files/alice"); err == nil {
+		t.Fatal("密码错误时应返回错误")
+	}
+}
+
+func TestWebDAVSinkMkdirAll(t *testing.T) {
+	s := newWebDAVStub(t)
+	d := newTestWebDAVSink(t, s)
+	if err := d.MkdirAll("团队/项目 A/设计"); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	// 已创建的目录不再请求，已存在的目录返回 405 视为成功
+	if err := d.MkdirAll("团队/项目 A"); err != nil {
+		t.Fatalf("MkdirAll: %v", err)
+	}
+	d.dirs = map[string]bool{}
+	if err := d.MkdirAll("团队"); err != nil {
+		t.Fatalf("目录已存在时 MkdirAll 失败: %v", err)
+	}
+
+	want := []string{"团队", "团队/项目 A", "团队/项目 A/设计"}
+	if strings.Join(s.dirs, "|") != strings.Join(want, "|") {
+		t.Errorf("MKCOL 依次创建 %q，应为 %q", s.dirs, want)
+	}
+}
+
+func TestWebDAVSinkPut(t *testing.T) {
+	s := newWebDAVStub(t)
+	d := newTestWebDAVSink(t, s)
+	content := "hello kdocs"
+	mtime := time.Unix(1700000000, 0)
+
+	w, err := d.Create("团队/报告 #1.docx", FileInfo{Size: int64(len(content)), ModTime: mtime})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := io.Copy(w, strings.NewReader(content)); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	f, ok := s.files["团队/报告 #1.docx"]
+	if !ok {
+		t.Fatalf("未上传文件，已上传: %v", s.files)
+	}
+	if f.body != content {
+		t.Errorf("上传内容 %q，应为 %q", f.body, content)
+	}
+	if f.mtime != "1700000000" {
+		t.Errorf("X-OC-MTime 为 %q，应为 1700000000", f.mtime)
+	}
+	if f.contentLength != int64(len(content)) {
+		t.Errorf("Content-Length 为 %d，应为 %d", f.contentLength, len(content))
+	}
+	if !s.hasDir("团队") {
+		t.Error("上传前应通过 MKCOL 创建父目录")
+	}
+}
+
+func TestWebDAVSinkPutUnknownSize(t *testing.T) {
+	s := newWebDAVStub(t)
+	d := newTestWebDAVSink(t, s)
+	w, err := d.Create("a.txt", FileInfo{Size: -1})
+	if err != nil {
+		t.Fatalf("Create: %v", err)
+	}
+	if _, err := io.WriteString(w, "abc"); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+
+	f := s.files["a.txt"]
+	if f.body != "abc" {
+		t.Errorf("上传内容 %q，应为 abc", f.body)
+	}
+	if f.mtime != "" {
+		t.Errorf("没有修改时间时不应设置 X-OC-MTime，实际为 %q", f.mtime)
+	}
+}
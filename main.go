@@ -2,44 +2,225 @@ package main
 
 import (
 	"flag"
+	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"KingExporter/internal/services/kdocs"
 	"KingExporter/pkg/display"
+	"KingExporter/pkg/office"
 )
 
 type flags struct {
-	silent      bool
+	common      commonFlags
+	scope       scopeFlags
 	downloadDir string
-	exportAll   bool
-	groupID     int
-	sid         string
+	allVersions bool
+	versions    string
+	writeMeta   bool
+	comments    bool
+	permissions bool
+	preload     time.Duration
+	markdown    bool
+	sheets      *kdocs.SpreadsheetProcessor
+	archive     string
+	dest        string
+	s3          kdocs.S3Options
+	mirror      bool
+	orphans     string
+	massDelete  bool
+	dedupe      string
+	maxSize     int64
+	checkSpace  bool
+	// args 为位置参数，set 为显式指定的参数名称
+	args []string
+	set  []string
 }
 
-func parseFlags() *flags {
+type command struct {
+	name  string
+	usage string
+	run   func(args []string)
+}
+
+var commands = []command{
+	{name: "whoami", usage: "查看当前登录用户", run: runWhoami},
+	{name: "groups", usage: "列出所有可访问的空间", run: runGroups},
+	{name: "ls", usage: "ls <group> [path]  列出空间中指定目录的文件", run: runLs},
+	{name: "tree", usage: "tree <group>  显示空间的完整目录树", run: runTree},
+	{name: "export", usage: "导出文档（默认命令）", run: runExport},
+	{name: "verify", usage: "verify <group>  校验本地导出目录是否完整", run: runVerify},
+	{name: "plan", usage: "plan --out plan.json  遍历远程目录并保存导出计划，不下载文件", run: runPlan},
+	{name: "apply", usage: "apply <plan.json>  执行保存的导出计划", run: runApply},
+}
+
+func parseExportFlags(name string, args []string) *flags {
 	f := &flags{}
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
 
-	flag.BoolVar(&f.silent, "s", false, "开启静默模式")
-	flag.StringVar(&f.downloadDir, "download_dir", "", "下载文件的目录")
-	flag.BoolVar(&f.exportAll, "A", false, "是否导出所有的文档，包括个人文档及团队文档")
-	flag.IntVar(&f.groupID, "group_id", 0, "导出指定空间的文档")
-	flag.StringVar(&f.sid, "sid", "", "金山文档的会话 ID")
+	f.common.register(fs)
+	fs.StringVar(&f.downloadDir, "download_dir", "", "下载文件的目录")
+	fs.StringVar(&f.archive, "output-archive", "", "将导出结果直接写入 .zip 或 .tar.gz 文件，设置后忽略 download_dir")
+	fs.StringVar(&f.dest, "dest", kdocs.DestinationLocal, "导出目的地: local 写入 download_dir，s3://bucket/prefix 上传到对象存储，davs://host/path 上传到 WebDAV")
+	fs.StringVar(&f.s3.Endpoint, "s3-endpoint", "", "对象存储地址，例如 http://localhost:9000，默认读取 AWS_ENDPOINT_URL 或使用 AWS S3")
+	fs.StringVar(&f.s3.Region, "s3-region", "", "对象存储区域")
+	fs.BoolVar(&f.s3.Insecure, "s3-insecure", false, "使用 HTTP 连接对象存储")
+	fs.BoolVar(&f.mirror, "mirror", false, "按上次导出记录的文件 ID 同步下载目录：云端重命名或移动的文件在本地移动，未变化的文件不再下载")
+	fs.StringVar(&f.orphans, "mirror-orphans", kdocs.OrphansMove, "镜像模式下云端已删除的文件: move 移动到 .orphaned/ 或 delete 删除")
+	fs.BoolVar(&f.massDelete, "mirror-allow-mass-delete", false, "镜像模式下一个 group 中超过一半的文件在云端消失时仍然清理")
+	fs.StringVar(&f.dedupe, "dedupe", "", "相同内容的文件只下载一次，其余以 hardlink、reflink 或 symlink 生成")
+	maxSize := fs.String("max-total-size", "", "总下载量上限，例如 500MB、10G，达到后停止下载，其余文件在清单中记为 skipped")
+	fs.BoolVar(&f.checkSpace, "check-space", false, "导出前遍历所有待导出的文件，估算下载量，本地剩余空间不足时拒绝导出")
+	f.scope.register(fs)
+	fs.BoolVar(&f.allVersions, "all-versions", false, "同时导出每个文件的所有历史版本")
+	fs.StringVar(&f.versions, "versions-layout", kdocs.VersionsLayoutSuffix, "历史版本存放方式: suffix (name.v<N>.<ext>) 或 dir (.versions/<file>/)")
+	fs.BoolVar(&f.writeMeta, "write-meta", false, "为每个文件及文件夹写入 <name>.meta.json 元数据（创建/修改时间、创建人、修改人）")
+	fs.BoolVar(&f.comments, "with-comments", false, "同时导出文档评论到 <name>.comments.json 及 <name>.comments.md")
+	fs.BoolVar(&f.permissions, "with-permissions", false, "收集空间成员及文件的分享链接、协作者，写入每个空间目录下的 permissions.json")
+	fs.DurationVar(&f.preload, "preload-timeout", kdocs.DefaultPreloadTimeout, "单次转码等待时间的上限，实际等待时间按文件大小计算")
+	fs.BoolVar(&f.markdown, "markdown", false, "为导出的 .docx（包括 .otl 转码的文档）额外生成 Markdown，图片提取到 assets/ 目录")
+	sheetsFormat := fs.String("sheets", "", "为导出的 .xlsx（包括 .ksheet 转码的表格）额外提取每个工作表: csv 或 json")
+	csvEncoding := fs.String("csv-encoding", office.EncodingUTF8, "提取 CSV 的编码: utf-8、utf-8-bom 或 gbk")
+	csvDelimiter := fs.String("csv-delimiter", ",", "提取 CSV 的分隔符，制表符可写作 tab")
 
-	flag.Parse()
+	f.args = parseArgs(fs, args)
+	fs.Visit(func(fl *flag.Flag) { f.set = append(f.set, fl.Name) })
+	if f.versions != kdocs.VersionsLayoutSuffix && f.versions != kdocs.VersionsLayoutDir {
+		display.Exit(2, "不支持的历史版本存放方式: %s", f.versions)
+	}
+	if f.orphans != kdocs.OrphansMove && f.orphans != kdocs.OrphansDelete {
+		display.Exit(2, "不支持的已删除文件处理方式: %s", f.orphans)
+	}
+	if err := kdocs.ValidateDedupe(f.dedupe); err != nil {
+		display.Exit(2, "%s", err)
+	}
+	f.scope.validate()
+	if *maxSize != "" {
+		size, err := display.ParseBytes(*maxSize)
+		if err != nil || size <= 0 {
+			display.Exit(2, "下载上限不合法: %s", *maxSize)
+		}
+		f.maxSize = size
+	}
+
+	if *sheetsFormat != "" {
+		delimiter, err := parseDelimiter(*csvDelimiter)
+		if err != nil {
+			display.Exit(2, "%s", err)
+		}
+		p := kdocs.SpreadsheetProcessor{
+			Format: *sheetsFormat,
+			CSV:    office.CSVOptions{Encoding: *csvEncoding, Delimiter: delimiter},
+		}
+		if err := p.Validate(); err != nil {
+			display.Exit(2, "表格提取参数不合法: %s", err)
+		}
+		f.sheets = &p
+	}
 	return f
 }
 
 func main() {
-	f := parseFlags()
-	e := kdocs.NewExporter(f.sid, kdocs.ExportOptions{
-		DownloadDir: f.downloadDir,
-		SilentMode:  f.silent,
-		ExportAll:   f.exportAll,
-		GroupID:     f.groupID,
+	args := os.Args[1:]
+	// 未指定子命令时保持原有的导出行为
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		runExport(args)
+		return
+	}
+
+	for _, c := range commands {
+		if c.name == args[0] {
+			c.run(args[1:])
+			return
+		}
+	}
+
+	printUsage()
+	os.Exit(2)
+}
+
+func printUsage() {
+	fmt.Fprintln(os.Stderr, "用法: KingExporter <command> [options]")
+	fmt.Fprintln(os.Stderr, "\n可用命令:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-8s %s\n", c.name, c.usage)
+	}
+	fmt.Fprintln(os.Stderr, "\n使用 KingExporter <command> -h 查看命令参数")
+}
+
+// parseDelimiter 解析 CSV 分隔符，支持 tab 及 \t 表示制表符
+func parseDelimiter(s string) (rune, error) {
+	if s == "tab" || s == `\t` {
+		return '\t', nil
+	}
+	r := []rune(s)
+	if len(r) != 1 {
+		return 0, fmt.Errorf("CSV 分隔符必须是单个字符: %q", s)
+	}
+	return r[0], nil
+}
+
+func runExport(args []string) {
+	export(parseExportFlags("export", args), nil)
+}
+
+// export 按参数导出，plan 不为空时执行导出计划
+func export(f *flags, plan *kdocs.Plan) {
+	silent := f.common.silent || f.common.isJSON()
+	var postProcessors []kdocs.PostProcessor
+	if f.markdown {
+		postProcessors = append(postProcessors, kdocs.MarkdownProcessor{})
+	}
+	if f.sheets != nil {
+		postProcessors = append(postProcessors, *f.sheets)
+	}
+
+	e := kdocs.NewExporter(f.common.sid, kdocs.ExportOptions{
+		DownloadDir:           f.downloadDir,
+		SilentMode:            silent,
+		Groups:                f.scope.groups.selector(),
+		Path:                  f.scope.remotePath,
+		FolderID:              f.scope.folderID,
+		FileID:                f.scope.fileID,
+		IncludeShared:         f.scope.shared,
+		IncludeStarred:        f.scope.starred,
+		IncludeRecent:         f.scope.recent,
+		IncludeTrash:          f.scope.trash,
+		AllVersions:           f.allVersions,
+		VersionsLayout:        f.versions,
+		WriteMeta:             f.writeMeta,
+		WithComments:          f.comments,
+		WithPermissions:       f.permissions,
+		Convert:               f.scope.convert,
+		PreloadTimeout:        f.preload,
+		PostProcessors:        postProcessors,
+		OutputArchive:         f.archive,
+		Destination:           f.dest,
+		S3:                    f.s3,
+		Mirror:                f.mirror,
+		MirrorOrphans:         f.orphans,
+		MirrorAllowMassDelete: f.massDelete,
+		Dedupe:                f.dedupe,
+		Names:                 f.scope.names.rules,
+		MaxTotalSize:          f.maxSize,
+		CheckSpace:            f.checkSpace,
+		Plan:                  plan,
 	})
 
 	dir := e.Export()
-	if !f.silent {
+	if f.common.isJSON() {
+		switch {
+		case f.archive != "":
+			printJSON(map[string]string{"archive": dir})
+		case f.dest != kdocs.DestinationLocal:
+			printJSON(map[string]string{"destination": dir})
+		default:
+			printJSON(map[string]string{"download_dir": dir})
+		}
+		return
+	}
+	if !silent {
 		waitForKeyPress(dir)
 	}
 }
@@ -0,0 +1,48 @@
+package display
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// PrintTable 以对齐的列输出表格，列宽按中日韩字符的显示宽度计算
+func PrintTable(w io.Writer, headers []string, rows [][]string) {
+	widths := make([]int, len(headers))
+	for i, h := range headers {
+		widths[i] = getStringDisplayWidth(h)
+	}
+	for _, row := range rows {
+		for i, cell := range row {
+			if i < len(widths) && getStringDisplayWidth(cell) > widths[i] {
+				widths[i] = getStringDisplayWidth(cell)
+			}
+		}
+	}
+
+	printRow := func(cells []string) {
+		var builder strings.Builder
+		for i, cell := range cells {
+			if i >= len(widths) {
+				break
+			}
+			if i == len(cells)-1 {
+				builder.WriteString(cell)
+				break
+			}
+			builder.WriteString(TruncateAndPad(cell, widths[i]))
+			builder.WriteString("  ")
+		}
+		fmt.Fprintln(w, strings.TrimRight(builder.String(), " "))
+	}
+
+	printRow(headers)
+	separators := make([]string, len(headers))
+	for i, width := range widths {
+		separators[i] = strings.Repeat("-", width)
+	}
+	printRow(separators)
+	for _, row := range rows {
+		printRow(row)
+	}
+}
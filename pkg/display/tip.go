@@ -3,7 +3,9 @@ package display
 import (
 	"fmt"
 	"io"
+	"math"
 	"os"
+	"strconv"
 	"strings"
 )
 
@@ -85,6 +87,12 @@ func (p *Printer) PrintInput(format string, args ...interface{}) {
 	fmt.Fprint(p.out, "> ")
 }
 
+// Progress displays a plain progress line on the error output, keeping the standard output
+// machine-readable for --format json
+func (p *Printer) Progress(format string, args ...interface{}) {
+	fmt.Fprintf(p.errOut, format+"\n", args...)
+}
+
 // Exit displays a message and exits with the specified code
 func (p *Printer) Exit(code int, format string, args ...interface{}) {
 	fmt.Fprintln(p.out, formatMessage(DefaultStyles.Exit, format, args...))
@@ -105,6 +113,10 @@ func PrintInput(format string, args ...interface{}) {
 	DefaultPrinter.PrintInput(format, args...)
 }
 
+func Progress(format string, args ...interface{}) {
+	DefaultPrinter.Progress(format, args...)
+}
+
 func Exit(code int, format string, args ...interface{}) {
 	DefaultPrinter.Exit(code, format, args...)
 }
@@ -144,3 +156,32 @@ func FormatBytes(bytes int64) string {
 		return fmt.Sprintf("%d B", bytes)
 	}
 }
+
+// ParseBytes parses sizes in the format of FormatBytes, such as 500MB, 10G or 1.5 TB.
+// Units are 1024-based and case-insensitive, a bare number is a byte count
+func ParseBytes(s string) (int64, error) {
+	units := []struct {
+		suffix string
+		size   float64
+	}{
+		{"PB", 1 << 50}, {"TB", 1 << 40}, {"GB", 1 << 30}, {"MB", 1 << 20}, {"KB", 1 << 10},
+		{"P", 1 << 50}, {"T", 1 << 40}, {"G", 1 << 30}, {"M", 1 << 20}, {"K", 1 << 10},
+		{"B", 1},
+	}
+
+	str := strings.ToUpper(strings.TrimSpace(s))
+	size := 1.0
+	for _, u := range units {
+		if strings.HasSuffix(str, u.suffix) {
+			str, size = strings.TrimSpace(strings.TrimSuffix(str, u.suffix)), u.size
+			break
+		}
+	}
+	n, err := strconv.ParseFloat(str, 64)
+	// !(v >= 0) 同时排除 NaN，float64(math.MaxInt64) 为 2^63，不小于它的值转换为 int64 时溢出
+	v := n * size
+	if err != nil || !(v >= 0) || v >= float64(math.MaxInt64) {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	return int64(v), nil
+}
@@ -0,0 +1,87 @@
+package office
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/simplifiedchinese"
+)
+
+// CSV 文件支持的编码
+const (
+	EncodingUTF8    = "utf-8"
+	EncodingUTF8BOM = "utf-8-bom"
+	EncodingGBK     = "gbk"
+)
+
+// CSVOptions 控制 CSV 的编码及分隔符
+type CSVOptions struct {
+	// Encoding 为空时使用 UTF-8
+	Encoding string
+	// Delimiter 为 0 时使用逗号
+	Delimiter rune
+}
+
+// Validate 检查编码及分隔符是否可用
+func (o CSVOptions) Validate() error {
+	switch o.Encoding {
+	case "", EncodingUTF8, EncodingUTF8BOM, EncodingGBK:
+	default:
+		return fmt.Errorf("不支持的编码 %s，可选 utf-8、utf-8-bom、gbk", o.Encoding)
+	}
+	if o.Delimiter != 0 && (o.Delimiter == '"' || o.Delimiter == '\r' || o.Delimiter == '\n' || !utf8.ValidRune(o.Delimiter) || o.Delimiter == utf8.RuneError) {
+		return fmt.Errorf("不支持的分隔符 %q", o.Delimiter)
+	}
+	return nil
+}
+
+// WriteCSV 将 rows 按 opts 写入 csvPath，各行补齐到相同列数
+func WriteCSV(csvPath string, rows [][]string, opts CSVOptions) (err error) {
+	if err := opts.Validate(); err != nil {
+		return err
+	}
+	f, err := os.Create(csvPath)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}()
+
+	var w io.Writer = f
+	switch opts.Encoding {
+	case EncodingUTF8BOM:
+		if _, err := f.WriteString("\uFEFF"); err != nil {
+			return err
+		}
+	case EncodingGBK:
+		// GBK 无法表示的字符替换为 ?，避免整个文件写入失败
+		w = encoding.ReplaceUnsupported(simplifiedchinese.GBK.NewEncoder()).Writer(f)
+	}
+
+	width := 0
+	for _, row := range rows {
+		width = max(width, len(row))
+	}
+
+	cw := csv.NewWriter(w)
+	if opts.Delimiter != 0 {
+		cw.Comma = opts.Delimiter
+	}
+	for _, row := range rows {
+		for len(row) < width {
+			row = append(row, "")
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
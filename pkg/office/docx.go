@@ -0,0 +1,454 @@
+package office
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const relNamespace = "http://schemas.openxmlformats.org/officeDocument/2006/relationships"
+
+var headingStyle = regexp.MustCompile(`(?i)^heading\s*([1-6])$`)
+
+// docxConverter 保存转换一个 .docx 所需的上下文
+type docxConverter struct {
+	zr *zip.Reader
+	// rels 为 document.xml 中引用的关系 ID 到目标的映射
+	rels map[string]string
+	// headings 为样式 ID 到标题级别的映射
+	headings map[string]int
+	// ordered 记录 numId/ilvl 是否为有序列表
+	ordered map[string]bool
+
+	assetsDir string
+	assetsRef string
+	images    map[string]string
+}
+
+// DocxToMarkdown 将 .docx 转换为 Markdown，保留标题、列表、表格及链接，
+// 图片提取到 assetsDir 并在 Markdown 中以 assetsRef 为前缀引用
+func DocxToMarkdown(docxPath, mdPath, assetsDir, assetsRef string) error {
+	zr, err := zip.OpenReader(docxPath)
+	if err != nil {
+		return fmt.Errorf("打开 docx 失败: %w", err)
+	}
+	defer zr.Close()
+
+	c := &docxConverter{
+		zr:        &zr.Reader,
+		assetsDir: assetsDir,
+		assetsRef: assetsRef,
+		images:    map[string]string{},
+	}
+	if c.rels, err = relationships(c.zr, "word/_rels/document.xml.rels"); err != nil {
+		return fmt.Errorf("解析 docx 关系失败: %w", err)
+	}
+	if err := c.loadStyles(); err != nil {
+		return fmt.Errorf("解析 docx 样式失败: %w", err)
+	}
+	if err := c.loadNumbering(); err != nil {
+		return fmt.Errorf("解析 docx 编号失败: %w", err)
+	}
+
+	doc, err := readZipXML(c.zr, "word/document.xml")
+	if err != nil || doc == nil {
+		return fmt.Errorf("解析 docx 正文失败: %v", err)
+	}
+	body := doc.child("body")
+	if body == nil {
+		return fmt.Errorf("docx 缺少正文")
+	}
+
+	var blocks []string
+	for _, n := range body.Children {
+		switch n.Name.Local {
+		case "p":
+			if md := c.paragraph(n); md != "" {
+				blocks = append(blocks, md)
+			}
+		case "tbl":
+			if md := c.table(n); md != "" {
+				blocks = append(blocks, md)
+			}
+		}
+	}
+
+	return os.WriteFile(mdPath, []byte(joinBlocks(blocks)), 0644)
+}
+
+// joinBlocks 用空行分隔段落，连续的列表项之间不留空行
+func joinBlocks(blocks []string) string {
+	var b strings.Builder
+	for i, block := range blocks {
+		if i > 0 {
+			if isListItem(blocks[i-1]) && isListItem(block) {
+				b.WriteString("\n")
+			} else {
+				b.WriteString("\n\n")
+			}
+		}
+		b.WriteString(block)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+var listItem = regexp.MustCompile(`^\s*(- |1\. )`)
+
+func isListItem(block string) bool {
+	return listItem.MatchString(block)
+}
+
+func (c *docxConverter) loadStyles() error {
+	c.headings = map[string]int{}
+	root, err := readZipXML(c.zr, "word/styles.xml")
+	if err != nil || root == nil {
+		return err
+	}
+
+	for _, s := range root.Children {
+		if s.Name.Local != "style" {
+			continue
+		}
+		id := s.attr("styleId")
+		if name := s.child("name"); name != nil {
+			v := name.attr("val")
+			if m := headingStyle.FindStringSubmatch(v); m != nil {
+				c.headings[id], _ = strconv.Atoi(m[1])
+				continue
+			}
+			if strings.EqualFold(v, "title") {
+				c.headings[id] = 1
+				continue
+			}
+		}
+		if lvl := s.find("outlineLvl"); lvl != nil {
+			if n, err := strconv.Atoi(lvl.attr("val")); err == nil && n < 6 {
+				c.headings[id] = n + 1
+			}
+		}
+	}
+	return nil
+}
+
+func (c *docxConverter) loadNumbering() error {
+	c.ordered = map[string]bool{}
+	root, err := readZipXML(c.zr, "word/numbering.xml")
+	if err != nil || root == nil {
+		return err
+	}
+
+	// abstractNumId/ilvl -> 是否有序
+	abstract := map[string]bool{}
+	for _, a := range root.Children {
+		if a.Name.Local != "abstractNum" {
+			continue
+		}
+		for _, lvl := range a.Children {
+			if lvl.Name.Local != "lvl" {
+				continue
+			}
+			fmtNode := lvl.child("numFmt")
+			abstract[a.attr("abstractNumId")+"/"+lvl.attr("ilvl")] = fmtNode != nil && fmtNode.attr("val") != "bullet" && fmtNode.attr("val") != "none"
+		}
+	}
+
+	for _, n := range root.Children {
+		if n.Name.Local != "num" {
+			continue
+		}
+		absID := n.child("abstractNumId")
+		if absID == nil {
+			continue
+		}
+		for key, ordered := range abstract {
+			prefix, ilvl, _ := strings.Cut(key, "/")
+			if prefix == absID.attr("val") {
+				c.ordered[n.attr("numId")+"/"+ilvl] = ordered
+			}
+		}
+	}
+	return nil
+}
+
+// paragraph 将 w:p 渲染为一行 Markdown
+func (c *docxConverter) paragraph(p *xmlNode) string {
+	text := strings.TrimSpace(c.inline(p))
+
+	var heading, level int
+	var numID string
+	isList := false
+	if pPr := p.child("pPr"); pPr != nil {
+		if style := pPr.child("pStyle"); style != nil {
+			heading = c.headings[style.attr("val")]
+		}
+		if lvl := pPr.child("outlineLvl"); lvl != nil && heading == 0 {
+			if n, err := strconv.Atoi(lvl.attr("val")); err == nil && n < 6 {
+				heading = n + 1
+			}
+		}
+		if numPr := pPr.child("numPr"); numPr != nil {
+			if id := numPr.child("numId"); id != nil && id.attr("val") != "0" {
+				isList = true
+				numID = id.attr("val")
+			}
+			if ilvl := numPr.child("ilvl"); ilvl != nil {
+				level, _ = strconv.Atoi(ilvl.attr("val"))
+			}
+		}
+	}
+
+	if text == "" {
+		return ""
+	}
+	text = escapeLineStart(text)
+	switch {
+	case heading > 0:
+		return strings.Repeat("#", heading) + " " + text
+	case isList:
+		marker := "- "
+		if c.ordered[numID+"/"+strconv.Itoa(level)] {
+			marker = "1. "
+		}
+		return strings.Repeat("  ", level) + marker + text
+	}
+	return text
+}
+
+type segment struct {
+	text   string
+	bold   bool
+	italic bool
+}
+
+// inline 渲染段落内的文字、链接和图片
+func (c *docxConverter) inline(n *xmlNode) string {
+	var segments []segment
+	var b strings.Builder
+
+	flush := func() {
+		b.WriteString(renderSegments(segments))
+		segments = nil
+	}
+
+	var walk func(n *xmlNode)
+	walk = func(n *xmlNode) {
+		for _, child := range n.Children {
+			switch child.Name.Local {
+			case "pPr", "rPr", "del", "instrText":
+				// 段落属性、修订删除的内容及域代码不输出
+			case "hyperlink":
+				flush()
+				target := c.rels[child.attrNS(relNamespace, "id")]
+				text := c.inline(child)
+				if target != "" && text != "" {
+					fmt.Fprintf(&b, "[%s](%s)", text, target)
+				} else {
+					b.WriteString(text)
+				}
+			case "r":
+				bold, italic := runStyle(child)
+				for _, rc := range child.Children {
+					switch rc.Name.Local {
+					case "t":
+						segments = append(segments, segment{text: escapeMarkdown(rc.Text), bold: bold, italic: italic})
+					case "tab":
+						segments = append(segments, segment{text: " "})
+					case "br", "cr":
+						segments = append(segments, segment{text: "  \n"})
+					case "drawing", "pict", "object":
+						if img := c.image(rc); img != "" {
+							flush()
+							b.WriteString(img)
+						}
+					}
+				}
+			default:
+				walk(child)
+			}
+		}
+	}
+	walk(n)
+	flush()
+
+	return b.String()
+}
+
+func runStyle(r *xmlNode) (bold, italic bool) {
+	rPr := r.child("rPr")
+	if rPr == nil {
+		return false, false
+	}
+	on := func(name string) bool {
+		n := rPr.child(name)
+		return n != nil && n.attr("val") != "0" && n.attr("val") != "false"
+	}
+	return on("b"), on("i")
+}
+
+// renderSegments 合并格式相同的相邻片段，避免输出 **a****b**
+func renderSegments(segments []segment) string {
+	var merged []segment
+	for _, s := range segments {
+		if len(merged) > 0 {
+			last := &merged[len(merged)-1]
+			if last.bold == s.bold && last.italic == s.italic {
+				last.text += s.text
+				continue
+			}
+		}
+		merged = append(merged, s)
+	}
+
+	var b strings.Builder
+	for _, s := range merged {
+		text := s.text
+		if strings.TrimSpace(text) == "" {
+			b.WriteString(text)
+			continue
+		}
+		mark := ""
+		if s.bold {
+			mark += "**"
+		}
+		if s.italic {
+			mark += "*"
+		}
+		// 标记需要紧贴文字，前后空白放在标记外
+		trimmed := strings.TrimSpace(text)
+		lead := text[:strings.Index(text, trimmed)]
+		tail := text[len(lead)+len(trimmed):]
+		b.WriteString(lead + mark + trimmed + reverse(mark) + tail)
+	}
+	return b.String()
+}
+
+func reverse(s string) string {
+	r := []rune(s)
+	for i, j := 0, len(r)-1; i < j; i, j = i+1, j-1 {
+		r[i], r[j] = r[j], r[i]
+	}
+	return string(r)
+}
+
+var markdownEscaper = strings.NewReplacer(`\`, `\\`, "*", `\*`, "_", `\_`, "`", "\\`", "[", `\[`, "]", `\]`, "<", `\<`)
+
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(s)
+}
+
+// escapeLineStart 转义行首的 #，避免普通文字被渲染为标题
+func escapeLineStart(s string) string {
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		if trimmed := strings.TrimLeft(line, " "); strings.HasPrefix(trimmed, "#") {
+			lines[i] = line[:len(line)-len(trimmed)] + `\` + trimmed
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// image 提取图片到资源目录并返回 Markdown 引用
+func (c *docxConverter) image(n *xmlNode) string {
+	id := ""
+	if blip := n.find("blip"); blip != nil {
+		id = blip.attrNS(relNamespace, "embed")
+	} else if data := n.find("imagedata"); data != nil {
+		id = data.attrNS(relNamespace, "id")
+	}
+	target := c.rels[id]
+	if target == "" {
+		return ""
+	}
+
+	if ref, ok := c.images[target]; ok {
+		return fmt.Sprintf("![](%s)", ref)
+	}
+
+	f := findZipFile(c.zr, path.Join("word", target))
+	if f == nil {
+		return ""
+	}
+	// 不同目录中的图片可能同名，按出现顺序加上序号
+	name := fmt.Sprintf("%d-%s", len(c.images)+1, path.Base(target))
+	if err := extractZipFile(f, filepath.Join(c.assetsDir, name)); err != nil {
+		return ""
+	}
+	// Markdown 链接中不能直接包含空格
+	ref := strings.ReplaceAll(path.Join(c.assetsRef, name), " ", "%20")
+	c.images[target] = ref
+	return fmt.Sprintf("![](%s)", ref)
+}
+
+func extractZipFile(f *zip.File, dst string) error {
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	out, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+	_, err = io.Copy(out, rc)
+	return err
+}
+
+// table 将 w:tbl 渲染为 Markdown 表格，首行作为表头
+func (c *docxConverter) table(tbl *xmlNode) string {
+	var rows [][]string
+	width := 0
+	for _, tr := range tbl.Children {
+		if tr.Name.Local != "tr" {
+			continue
+		}
+		var row []string
+		for _, tc := range tr.Children {
+			if tc.Name.Local != "tc" {
+				continue
+			}
+			var lines []string
+			for _, p := range tc.Children {
+				if p.Name.Local == "p" {
+					if text := strings.TrimSpace(c.inline(p)); text != "" {
+						lines = append(lines, text)
+					}
+				}
+			}
+			cell := strings.Join(lines, "<br>")
+			cell = strings.ReplaceAll(strings.ReplaceAll(cell, "\n", " "), "|", `\|`)
+			row = append(row, cell)
+		}
+		width = max(width, len(row))
+		rows = append(rows, row)
+	}
+	if len(rows) == 0 || width == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	for i, row := range rows {
+		for len(row) < width {
+			row = append(row, "")
+		}
+		b.WriteString("| " + strings.Join(row, " | ") + " |")
+		if i == 0 {
+			b.WriteString("\n|" + strings.Repeat(" --- |", width))
+		}
+		if i < len(rows)-1 {
+			b.WriteString("\n")
+		}
+	}
+	return b.String()
+}
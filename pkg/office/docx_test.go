@@ -0,0 +1,86 @@
+package office
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+const testDocumentRels = `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="image" Target="media/a/image1.png"/>
+<Relationship Id="rId2" Type="image" Target="media/b/image1.png"/></Relationships>`
+
+func documentXML(body string) string {
+	return `<w:document xmlns:w="http://schemas.openxmlformats.org/wordprocessingml/2006/main" xmlns:a="http://schemas.openxmlformats.org/drawingml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships"><w:body>` +
+		body + `</w:body></w:document>`
+}
+
+func imageRun(id string) string {
+	return `<w:r><w:drawing><a:blip r:embed="` + id + `"/></w:drawing></w:r>`
+}
+
+func textParagraph(text string) string {
+	return `<w:p><w:r><w:t xml:space="preserve">` + text + `</w:t></w:r></w:p>`
+}
+
+// convertDocx 将 body 写入 docx 并转换为 Markdown，返回 Markdown 及图片目录
+func convertDocx(t *testing.T, body string, files map[string]string) (string, string) {
+	t.Helper()
+	if files == nil {
+		files = map[string]string{}
+	}
+	files["word/document.xml"] = documentXML(body)
+	files["word/_rels/document.xml.rels"] = testDocumentRels
+	p := writeZip(t, "doc.docx", files)
+
+	dir := t.TempDir()
+	mdPath := filepath.Join(dir, "doc.md")
+	assetsDir := filepath.Join(dir, "assets", "doc")
+	if err := DocxToMarkdown(p, mdPath, assetsDir, "assets/doc"); err != nil {
+		t.Fatalf("DocxToMarkdown: %v", err)
+	}
+	data, err := os.ReadFile(mdPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(data), assetsDir
+}
+
+func TestDocxImagesWithSameName(t *testing.T) {
+	md, assetsDir := convertDocx(t, `<w:p>`+imageRun("rId1")+imageRun("rId2")+imageRun("rId1")+`</w:p>`, map[string]string{
+		"word/media/a/image1.png": "a",
+		"word/media/b/image1.png": "b",
+	})
+
+	want := "![](assets/doc/1-image1.png)![](assets/doc/2-image1.png)![](assets/doc/1-image1.png)\n"
+	if md != want {
+		t.Errorf("Markdown 为 %q，应为 %q", md, want)
+	}
+	for name, content := range map[string]string{"1-image1.png": "a", "2-image1.png": "b"} {
+		data, err := os.ReadFile(filepath.Join(assetsDir, name))
+		if err != nil || string(data) != content {
+			t.Errorf("图片 %s 的内容为 %q, %v，应为 %q", name, data, err, content)
+		}
+	}
+}
+
+func TestDocxEscaping(t *testing.T) {
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"# 不是标题", `\# 不是标题`},
+		{"  #标签", `\#标签`},
+		{"编号 #1", "编号 #1"},
+		{"[链接](http://a)", `\[链接\](http://a)`},
+		{"&lt;script&gt;", `\<script>`},
+		{"a*b_c`d\\e", "a\\*b\\_c\\`d\\\\e"},
+	}
+	for _, tt := range tests {
+		md, _ := convertDocx(t, textParagraph(tt.text), nil)
+		if got := strings.TrimSuffix(md, "\n"); got != tt.want {
+			t.Errorf("%q 转换为 %q，应为 %q", tt.text, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,384 @@
+package office
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Sheet 是工作表解析后的单元格文本，按行列排列
+type Sheet struct {
+	Name string     `json:"name"`
+	Rows [][]string `json:"rows"`
+}
+
+// xlsxReader 保存解析一个工作簿所需的上下文
+type xlsxReader struct {
+	zr *zip.Reader
+	// strings 为共享字符串表
+	strings []string
+	// dateStyles 记录单元格样式索引是否为日期格式
+	dateStyles []bool
+	date1904   bool
+}
+
+// ReadWorkbook 解析 .xlsx 中的所有工作表，日期按 ISO 格式输出，其余单元格保留原始值
+func ReadWorkbook(xlsxPath string) ([]Sheet, error) {
+	zr, err := zip.OpenReader(xlsxPath)
+	if err != nil {
+		return nil, fmt.Errorf("打开 xlsx 失败: %w", err)
+	}
+	defer zr.Close()
+
+	r := &xlsxReader{zr: &zr.Reader}
+	if err := r.loadSharedStrings(); err != nil {
+		return nil, fmt.Errorf("解析共享字符串失败: %w", err)
+	}
+	if err := r.loadStyles(); err != nil {
+		return nil, fmt.Errorf("解析 xlsx 样式失败: %w", err)
+	}
+	entries, err := r.loadWorkbook()
+	if err != nil {
+		return nil, err
+	}
+
+	var sheets []Sheet
+	for _, entry := range entries {
+		rows, err := r.readSheet(entry.file)
+		if err != nil {
+			return nil, fmt.Errorf("解析工作表 %s 失败: %w", entry.name, err)
+		}
+		sheets = append(sheets, Sheet{Name: entry.name, Rows: rows})
+	}
+	return sheets, nil
+}
+
+// SheetNames 返回 .xlsx 中的工作表名称，与 ReadWorkbook 返回的工作表一一对应，不解析单元格
+func SheetNames(xlsxPath string) ([]string, error) {
+	zr, err := zip.OpenReader(xlsxPath)
+	if err != nil {
+		return nil, fmt.Errorf("打开 xlsx 失败: %w", err)
+	}
+	defer zr.Close()
+
+	r := &xlsxReader{zr: &zr.Reader}
+	entries, err := r.loadWorkbook()
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(entries))
+	for _, entry := range entries {
+		names = append(names, entry.name)
+	}
+	return names, nil
+}
+
+// sheetEntry 是 workbook.xml 中登记的工作表及其 XML 文件
+type sheetEntry struct {
+	name string
+	file *zip.File
+}
+
+// loadWorkbook 解析 workbook.xml，返回存在 XML 文件的工作表
+func (r *xlsxReader) loadWorkbook() ([]sheetEntry, error) {
+	workbook, err := readZipXML(r.zr, "xl/workbook.xml")
+	if err != nil || workbook == nil {
+		return nil, fmt.Errorf("解析 workbook.xml 失败: %v", err)
+	}
+	if pr := workbook.child("workbookPr"); pr != nil {
+		v := pr.attr("date1904")
+		r.date1904 = v == "1" || v == "true"
+	}
+	rels, err := relationships(r.zr, "xl/_rels/workbook.xml.rels")
+	if err != nil {
+		return nil, fmt.Errorf("解析工作簿关系失败: %w", err)
+	}
+
+	var entries []sheetEntry
+	list := workbook.child("sheets")
+	if list == nil {
+		return nil, nil
+	}
+	for _, s := range list.Children {
+		if s.Name.Local != "sheet" {
+			continue
+		}
+		target := rels[s.attrNS(relNamespace, "id")]
+		if target == "" {
+			continue
+		}
+		// 关系目标可以是相对 xl/ 的路径，也可以是以 / 开头的绝对路径
+		if strings.HasPrefix(target, "/") {
+			target = strings.TrimPrefix(target, "/")
+		} else {
+			target = path.Join("xl", target)
+		}
+		f := findZipFile(r.zr, target)
+		if f == nil {
+			continue
+		}
+		entries = append(entries, sheetEntry{name: s.attr("name"), file: f})
+	}
+	return entries, nil
+}
+
+func (r *xlsxReader) loadSharedStrings() error {
+	root, err := readZipXML(r.zr, "xl/sharedStrings.xml")
+	if err != nil || root == nil {
+		return err
+	}
+	for _, si := range root.Children {
+		if si.Name.Local == "si" {
+			r.strings = append(r.strings, richText(si))
+		}
+	}
+	return nil
+}
+
+// richText 拼接 si/is 中所有 t 元素的文本，忽略注音
+func richText(n *xmlNode) string {
+	var b strings.Builder
+	var walk func(n *xmlNode)
+	walk = func(n *xmlNode) {
+		for _, c := range n.Children {
+			switch c.Name.Local {
+			case "t":
+				b.WriteString(c.Text)
+			case "rPh":
+			default:
+				walk(c)
+			}
+		}
+	}
+	walk(n)
+	return b.String()
+}
+
+// 内置的日期格式 ID，参见 ECMA-376 18.8.30
+var builtinDateFormats = map[int]bool{
+	14: true, 15: true, 16: true, 17: true, 18: true, 19: true, 20: true, 21: true, 22: true,
+	27: true, 30: true, 36: true, 45: true, 46: true, 47: true, 50: true, 57: true,
+}
+
+// 去掉引号内文本、转义字符及颜色等方括号内容后，包含 y/m/d/h/s 即视为日期格式
+var (
+	formatNoise = regexp.MustCompile(`"[^"]*"|\\.|\[[^\]]*\]`)
+	dateTokens  = regexp.MustCompile(`(?i)[ymdhs]`)
+)
+
+func (r *xlsxReader) loadStyles() error {
+	root, err := readZipXML(r.zr, "xl/styles.xml")
+	if err != nil || root == nil {
+		return err
+	}
+
+	custom := map[int]bool{}
+	if numFmts := root.child("numFmts"); numFmts != nil {
+		for _, f := range numFmts.Children {
+			id, err := strconv.Atoi(f.attr("numFmtId"))
+			if err != nil {
+				continue
+			}
+			code := formatNoise.ReplaceAllString(f.attr("formatCode"), "")
+			custom[id] = dateTokens.MatchString(code)
+		}
+	}
+
+	if xfs := root.child("cellXfs"); xfs != nil {
+		for _, xf := range xfs.Children {
+			if xf.Name.Local != "xf" {
+				continue
+			}
+			id, _ := strconv.Atoi(xf.attr("numFmtId"))
+			isDate, ok := custom[id]
+			if !ok {
+				isDate = builtinDateFormats[id]
+			}
+			r.dateStyles = append(r.dateStyles, isDate)
+		}
+	}
+	return nil
+}
+
+// 工作表的行数及列数上限，超出范围的单元格视为无效并忽略
+const (
+	maxSheetRows = 1048576
+	maxSheetCols = 16384
+)
+
+// cell 是解析单元格时的中间状态
+type cell struct {
+	col   int
+	typ   string
+	style int
+	value strings.Builder
+}
+
+// readSheet 流式解析工作表，避免大表格整体加载为 XML 树。
+// 只有带值的单元格计入行宽，只有样式的空单元格不会让行或列变长
+func (r *xlsxReader) readSheet(f *zip.File) ([][]string, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return r.parseSheet(rc)
+}
+
+func (r *xlsxReader) parseSheet(rd io.Reader) ([][]string, error) {
+	var rows [][]string
+	var row []string
+	var c *cell
+	// inValue 表示当前位于 v 或 is/t 中
+	inValue := false
+	nextCol := 0
+	// rowNum 为当前行从 1 开始的行号，没有 r 属性时为上一行的下一行
+	rowNum := 0
+
+	dec := xml.NewDecoder(rd)
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			switch t.Name.Local {
+			case "row":
+				rowNum++
+				if n, err := strconv.Atoi(attrValue(t, "r")); err == nil && n > 0 {
+					rowNum = n
+				}
+				row, nextCol = []string{}, 0
+			case "c":
+				c = &cell{col: nextCol, typ: attrValue(t, "t")}
+				if ref := attrValue(t, "r"); ref != "" {
+					if col, ok := columnIndex(ref); ok {
+						c.col = col
+					}
+				}
+				c.style, _ = strconv.Atoi(attrValue(t, "s"))
+			case "v", "t":
+				inValue = c != nil
+			case "rPh":
+				// 注音文本不输出
+				if err := dec.Skip(); err != nil {
+					return nil, err
+				}
+			}
+		case xml.CharData:
+			if inValue {
+				c.value.Write(t)
+			}
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "v", "t":
+				inValue = false
+			case "c":
+				if c == nil {
+					continue
+				}
+				if text := r.cellText(c); text != "" && c.col < maxSheetCols {
+					for len(row) < c.col {
+						row = append(row, "")
+					}
+					row = append(row, text)
+				}
+				nextCol = c.col + 1
+				c = nil
+			case "row":
+				// 跳过的行及空行在后面有内容时补为空行，保持行号一致
+				if len(row) == 0 || rowNum > maxSheetRows {
+					continue
+				}
+				for len(rows) < rowNum-1 {
+					rows = append(rows, []string{})
+				}
+				rows = append(rows, row)
+			}
+		}
+	}
+
+	return rows, nil
+}
+
+func (r *xlsxReader) cellText(c *cell) string {
+	v := c.value.String()
+	switch c.typ {
+	case "s":
+		i, err := strconv.Atoi(strings.TrimSpace(v))
+		if err != nil || i < 0 || i >= len(r.strings) {
+			return ""
+		}
+		return r.strings[i]
+	case "b":
+		if v == "1" {
+			return "TRUE"
+		}
+		return "FALSE"
+	case "inlineStr", "str", "e":
+		return v
+	}
+
+	if v != "" && c.style >= 0 && c.style < len(r.dateStyles) && r.dateStyles[c.style] {
+		if n, err := strconv.ParseFloat(v, 64); err == nil {
+			return serialToTime(n, r.date1904)
+		}
+	}
+	return v
+}
+
+// serialToTime 将 Excel 日期序列号转换为 ISO 格式，带时间部分时精确到秒
+func serialToTime(serial float64, date1904 bool) string {
+	epoch := time.Date(1899, 12, 30, 0, 0, 0, 0, time.UTC)
+	if date1904 {
+		epoch = time.Date(1904, 1, 1, 0, 0, 0, 0, time.UTC)
+	}
+	days := math.Floor(serial)
+	seconds := math.Round((serial - days) * 86400)
+	t := epoch.AddDate(0, 0, int(days)).Add(time.Duration(seconds) * time.Second)
+	if seconds == 0 {
+		return t.Format("2006-01-02")
+	}
+	if days == 0 && !date1904 {
+		return t.Format("15:04:05")
+	}
+	return t.Format("2006-01-02 15:04:05")
+}
+
+// columnIndex 将 A1 形式的单元格引用转换为从 0 开始的列号，超过 XFD 时返回 maxSheetCols
+func columnIndex(ref string) (int, bool) {
+	col := 0
+	n := 0
+	for _, ch := range ref {
+		if ch >= 'a' && ch <= 'z' {
+			ch -= 'a' - 'A'
+		}
+		if ch < 'A' || ch > 'Z' {
+			break
+		}
+		col = min(col*26+int(ch-'A'+1), maxSheetCols+1)
+		n++
+	}
+	return col - 1, n > 0
+}
+
+func attrValue(e xml.StartElement, local string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
@@ -0,0 +1,159 @@
+package office
+
+import (
+	"archive/zip"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// writeZip 将 files 写入 dir 下的 name，返回文件路径
+func writeZip(t *testing.T, name string, files map[string]string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	f, err := os.Create(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zw := zip.NewWriter(f)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+const testWorkbook = `<workbook xmlns="http://schemas.openxmlformats.org/spreadsheetml/2006/main" xmlns:r="http://schemas.openxmlformats.org/officeDocument/2006/relationships">
+<sheets><sheet name="数据" sheetId="1" r:id="rId1"/><sheet name="Empty" sheetId="2" r:id="rId2"/></sheets></workbook>`
+
+const testWorkbookRels = `<Relationships xmlns="http://schemas.openxmlformats.org/package/2006/relationships">
+<Relationship Id="rId1" Type="worksheet" Target="worksheets/sheet1.xml"/>
+<Relationship Id="rId2" Type="worksheet" Target="/xl/worksheets/sheet2.xml"/></Relationships>`
+
+const testStyles = `<styleSheet><numFmts><numFmt numFmtId="164" formatCode="yyyy/mm/dd"/><numFmt numFmtId="165" formatCode="&quot;day&quot; 0"/></numFmts>
+<cellXfs><xf numFmtId="0"/><xf numFmtId="164"/><xf numFmtId="14"/><xf numFmtId="165"/></cellXfs></styleSheet>`
+
+const testSharedStrings = `<sst><si><t>名称</t></si><si><r><t>富</t></r><r><t>文本</t></r><rPh><t>ふ</t></rPh></si></sst>`
+
+func sheetXML(rows string) string {
+	return `<worksheet><sheetData>` + rows + `</sheetData></worksheet>`
+}
+
+func TestReadWorkbook(t *testing.T) {
+	p := writeZip(t, "book.xlsx", map[string]string{
+		"xl/workbook.xml":            testWorkbook,
+		"xl/_rels/workbook.xml.rels": testWorkbookRels,
+		"xl/styles.xml":              testStyles,
+		"xl/sharedStrings.xml":       testSharedStrings,
+		"xl/worksheets/sheet1.xml": sheetXML(`
+<row r="1"><c r="A1" t="s"><v>0</v></c><c r="C1" t="s"><v>1</v></c></row>
+<row r="3"><c r="A3" s="1"><v>45292</v></c><c r="B3" s="2"><v>45292.5</v></c><c r="C3" s="3"><v>7</v></c></row>
+<row r="4"><c t="b"><v>1</v></c><c t="inlineStr"><is><t>行内</t></is></c><c t="str"><v>=A1</v></c></row>
+<row r="5"><c r="A5" s="1"/><c r="B5" t="s"><v>99</v></c></row>`),
+		"xl/worksheets/sheet2.xml": sheetXML(``),
+	})
+
+	sheets, err := ReadWorkbook(p)
+	if err != nil {
+		t.Fatalf("ReadWorkbook: %v", err)
+	}
+	if len(sheets) != 2 || sheets[0].Name != "数据" || sheets[1].Name != "Empty" {
+		t.Fatalf("工作表为 %+v", sheets)
+	}
+	want := [][]string{
+		{"名称", "", "富文本"},
+		{},
+		{"2024-01-01", "2024-01-01 12:00:00", "7"},
+		{"TRUE", "行内", "=A1"},
+	}
+	if !reflect.DeepEqual(sheets[0].Rows, want) {
+		t.Errorf("单元格为 %q，应为 %q", sheets[0].Rows, want)
+	}
+	if len(sheets[1].Rows) != 0 {
+		t.Errorf("空工作表的单元格为 %q", sheets[1].Rows)
+	}
+
+	names, err := SheetNames(p)
+	if err != nil {
+		t.Fatalf("SheetNames: %v", err)
+	}
+	if !reflect.DeepEqual(names, []string{"数据", "Empty"}) {
+		t.Errorf("工作表名称为 %q", names)
+	}
+}
+
+func TestParseSheetLimits(t *testing.T) {
+	r := &xlsxReader{}
+	tests := []struct {
+		name string
+		xml  string
+		want [][]string
+	}{
+		{
+			name: "只有样式的空单元格不计入行宽及行数",
+			xml:  `<row r="1"><c r="A1" t="str"><v>a</v></c><c r="XFD1" s="1"/></row><row r="1048576"><c r="XFD1048576" s="1"/></row>`,
+			want: [][]string{{"a"}},
+		},
+		{
+			name: "超出列数上限的单元格忽略",
+			xml:  `<row r="1"><c r="A1" t="str"><v>a</v></c><c r="XFE1" t="str"><v>b</v></c><c r="ZZZZZZZZZZZZZZ1" t="str"><v>c</v></c></row>`,
+			want: [][]string{{"a"}},
+		},
+		{
+			name: "超出行数上限的行忽略",
+			xml:  `<row r="1"><c r="A1" t="str"><v>a</v></c></row><row r="1048577"><c r="A1048577" t="str"><v>b</v></c></row><row r="99999999999999999999"><c t="str"><v>c</v></c></row>`,
+			want: [][]string{{"a"}},
+		},
+		{
+			name: "没有引用的单元格及行依次排列",
+			xml:  `<row><c t="str"><v>a</v></c><c t="str"><v>b</v></c></row><row/><row><c r="C3" t="str"><v>c</v></c></row>`,
+			want: [][]string{{"a", "b"}, {}, {"", "", "c"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rows, err := r.parseSheet(strings.NewReader(sheetXML(tt.xml)))
+			if err != nil {
+				t.Fatalf("parseSheet: %v", err)
+			}
+			if !reflect.DeepEqual(rows, tt.want) {
+				t.Errorf("单元格为 %q，应为 %q", rows, tt.want)
+			}
+		})
+	}
+}
+
+func TestColumnIndex(t *testing.T) {
+	tests := []struct {
+		ref  string
+		want int
+		ok   bool
+	}{
+		{"A1", 0, true},
+		{"z9", 25, true},
+		{"AA10", 26, true},
+		{"XFD1048576", maxSheetCols - 1, true},
+		{"XFE1", maxSheetCols, true},
+		{"ZZZZZZZZZZZZZZZZ1", maxSheetCols, true},
+		{"12", -1, false},
+	}
+	for _, tt := range tests {
+		got, ok := columnIndex(tt.ref)
+		if ok != tt.ok || (ok && got != tt.want) {
+			t.Errorf("columnIndex(%q) = %d, %v，应为 %d, %v", tt.ref, got, ok, tt.want, tt.ok)
+		}
+	}
+}
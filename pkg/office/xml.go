@@ -0,0 +1,132 @@
+package office
+
+import (
+	"archive/zip"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"path"
+)
+
+// xmlNode 是解析后的 XML 元素，保留子元素顺序，便于按文档顺序遍历
+type xmlNode struct {
+	Name     xml.Name
+	Attrs    []xml.Attr
+	Children []*xmlNode
+	Text     string
+}
+
+func parseXML(r io.Reader) (*xmlNode, error) {
+	dec := xml.NewDecoder(r)
+	root := &xmlNode{}
+	stack := []*xmlNode{root}
+
+	for {
+		tok, err := dec.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		parent := stack[len(stack)-1]
+		switch t := tok.(type) {
+		case xml.StartElement:
+			n := &xmlNode{Name: t.Name, Attrs: t.Attr}
+			parent.Children = append(parent.Children, n)
+			stack = append(stack, n)
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			parent.Text += string(t)
+		}
+	}
+
+	if len(root.Children) == 0 {
+		return nil, fmt.Errorf("empty xml document")
+	}
+	return root.Children[0], nil
+}
+
+// attr 按本地名称查找属性，忽略命名空间
+func (n *xmlNode) attr(local string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// attrNS 按命名空间及本地名称查找属性
+func (n *xmlNode) attrNS(space, local string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Space == space && a.Name.Local == local {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+func (n *xmlNode) child(local string) *xmlNode {
+	for _, c := range n.Children {
+		if c.Name.Local == local {
+			return c
+		}
+	}
+	return nil
+}
+
+// find 深度优先查找第一个名为 local 的后代元素
+func (n *xmlNode) find(local string) *xmlNode {
+	for _, c := range n.Children {
+		if c.Name.Local == local {
+			return c
+		}
+		if found := c.find(local); found != nil {
+			return found
+		}
+	}
+	return nil
+}
+
+// readZipXML 读取并解析压缩包中的 XML 文件，文件不存在时返回 nil
+func readZipXML(zr *zip.Reader, name string) (*xmlNode, error) {
+	f := findZipFile(zr, name)
+	if f == nil {
+		return nil, nil
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return parseXML(rc)
+}
+
+func findZipFile(zr *zip.Reader, name string) *zip.File {
+	name = path.Clean(name)
+	for _, f := range zr.File {
+		if path.Clean(f.Name) == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// relationships 解析 .rels 文件，返回 ID 到目标路径的映射
+func relationships(zr *zip.Reader, name string) (map[string]string, error) {
+	root, err := readZipXML(zr, name)
+	if err != nil || root == nil {
+		return map[string]string{}, err
+	}
+
+	rels := map[string]string{}
+	for _, c := range root.Children {
+		if c.Name.Local == "Relationship" {
+			rels[c.attr("Id")] = c.attr("Target")
+		}
+	}
+	return rels, nil
+}